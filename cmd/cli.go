@@ -0,0 +1,216 @@
+package main
+
+import (
+	"api-gateway-service-ms/config"
+	"api-gateway-service-ms/internal/pkg/cache"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// DEFAULT_CONFIG_PATH is the directory holding config.yaml, relative to the working directory
+const DEFAULT_CONFIG_PATH = "config"
+
+const usage = `Usage: api-gateway <command> [flags]
+
+Commands:
+  serve             Start the gateway (default)
+  config validate   Validate the configuration and exit
+  config print      Print the effective configuration after overlays and environment overrides
+  routes list       List the gateway routes and proxied services
+  replay            Replay recorded traffic against a gateway or backend and report the differences
+
+Run "api-gateway <command> -h" for the flags of a command.
+`
+
+// command is a CLI subcommand, parsing its own flags from args
+type command func(args []string, stdout io.Writer) error
+
+var commands = map[string]command{
+	"serve":           serveCommand,
+	"config validate": validateCommand,
+	"config print":    printCommand,
+	"routes list":     routesCommand,
+	"replay":          replayCommand,
+}
+
+// run dispatches args to a subcommand and returns the process exit code
+func run(args []string) int {
+	name, rest := "serve", args
+	if len(args) > 0 && args[0] != "" && args[0][0] != '-' {
+		name, rest = args[0], args[1:]
+		if len(rest) > 0 && rest[0] != "" && rest[0][0] != '-' {
+			if _, ok := commands[name+" "+rest[0]]; ok {
+				name, rest = name+" "+rest[0], rest[1:]
+			}
+		}
+	}
+
+	cmd, ok := commands[name]
+	if !ok {
+		if name != "help" {
+			fmt.Fprintf(os.Stderr, "Unknown command %q\n\n", name)
+		}
+		fmt.Fprint(os.Stderr, usage)
+		return 2
+	}
+
+	if err := cmd(rest, os.Stdout); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	return 0
+}
+
+// newFlagSet returns the flags shared by all commands
+func newFlagSet(name string) (*flag.FlagSet, *string) {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	configPath := fs.String("config", DEFAULT_CONFIG_PATH, "directory containing config.yaml")
+	return fs, configPath
+}
+
+func serveCommand(args []string, stdout io.Writer) error {
+	fs, configPath := newFlagSet("serve")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if err := setupConfig(*configPath); err != nil {
+		return err
+	}
+
+	setupLogger(logrus.InfoLevel)
+	if err := setupCache(); err != nil {
+		return err
+	}
+
+	if err := serve(); err != nil {
+		return fmt.Errorf("failed to start the server: %w", err)
+	}
+
+	return nil
+}
+
+// validateCommand checks the configuration, so CI can reject a change before deploy
+func validateCommand(args []string, stdout io.Writer) error {
+	fs, configPath := newFlagSet("config validate")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if err := setupConfig(*configPath); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(stdout, "Configuration in %s is valid\n", *configPath)
+	return nil
+}
+
+// printCommand prints the effective configuration as YAML, after environment overrides
+func printCommand(args []string, stdout io.Writer) error {
+	fs, configPath := newFlagSet("config print")
+	redact := fs.Bool("redact", true, "replace secret values with "+config.REDACTED+", use --redact=false to show them")
+	provenance := fs.Bool("provenance", false, "print each setting with the file or environment variable it came from, secrets are always redacted")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if err := setupConfig(*configPath); err != nil {
+		return err
+	}
+
+	if *provenance {
+		return printProvenance(stdout)
+	}
+
+	cfg := configManager.Get()
+	if *redact {
+		cfg = config.Redact(cfg)
+	}
+
+	out, err := yaml.Marshal(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to print config: %w", err)
+	}
+
+	_, err = stdout.Write(out)
+	return err
+}
+
+// printProvenance prints every setting with its value and source, sorted by setting
+func printProvenance(stdout io.Writer) error {
+	sources := configManager.Sources()
+	values := config.Flatten(configManager.Get())
+
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	w := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "# Files merged in order: %s\n", strings.Join(sources.Files, ", "))
+	fmt.Fprintln(w, "SETTING\tVALUE\tSOURCE")
+	for _, key := range keys {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", key, values[key], sources.Source(key))
+	}
+
+	return w.Flush()
+}
+
+// routesCommand lists the routes registered on the router and the proxied services.
+// The router is built on an in-memory cache, so no cache server is needed.
+func routesCommand(args []string, stdout io.Writer) error {
+	fs, configPath := newFlagSet("routes list")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if err := setupConfig(*configPath); err != nil {
+		return err
+	}
+
+	setupLogger(logrus.WarnLevel)
+	pkgCache = cache.NewMemoryCache(pkgLogger)
+	gin.SetMode(gin.ReleaseMode)
+
+	routes := newRouter().Routes()
+	sort.Slice(routes, func(i, j int) bool {
+		if routes[i].Path != routes[j].Path {
+			return routes[i].Path < routes[j].Path
+		}
+		return routes[i].Method < routes[j].Method
+	})
+
+	w := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "METHOD\tPATH\tTARGET")
+	for _, route := range routes {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", route.Method, route.Path, route.Handler)
+	}
+
+	services := configManager.Get().FowardServiceUrl
+	names := make([]string, 0, len(services))
+	for name := range services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		fmt.Fprintf(w, "ANY\t/%s/*path\t%s\n", name, services[name])
+	}
+
+	return w.Flush()
+}
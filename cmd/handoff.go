@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// HANDOFF_LISTENERS_ENV lists the addresses of the listeners passed to a new process,
+	// in the order of their file descriptors starting at 3
+	HANDOFF_LISTENERS_ENV = "GATEWAY_LISTENERS"
+	// HANDOFF_READY_ENV is the file descriptor the new process closes once it serves
+	HANDOFF_READY_ENV = "GATEWAY_READY_FD"
+	// HANDOFF_TIMEOUT bounds the wait for the new process to serve
+	HANDOFF_TIMEOUT = 30 * time.Second
+)
+
+// inheritedListeners returns the listeners passed by the process that handed off to this
+// one, by their configured address
+func inheritedListeners() (map[string]net.Listener, error) {
+	value := os.Getenv(HANDOFF_LISTENERS_ENV)
+	if value == "" {
+		return nil, nil
+	}
+	os.Unsetenv(HANDOFF_LISTENERS_ENV)
+
+	listeners := make(map[string]net.Listener)
+	for i, addr := range strings.Split(value, ",") {
+		file := os.NewFile(uintptr(3+i), "listener:"+addr)
+		ln, err := net.FileListener(file)
+		file.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to inherit listener %s: %w", addr, err)
+		}
+		listeners[addr] = ln
+	}
+
+	return listeners, nil
+}
+
+// listen returns the inherited listener of addr, or binds a new one
+func listen(addr string, reusePort bool, inherited map[string]net.Listener) (net.Listener, error) {
+	if ln, ok := inherited[addr]; ok {
+		delete(inherited, addr)
+		pkgLogger.Infof("Inherited listener on %s", addr)
+		return ln, nil
+	}
+
+	var lc net.ListenConfig
+	if reusePort {
+		lc.Control = func(network, address string, conn syscall.RawConn) error {
+			var err error
+			if controlErr := conn.Control(func(fd uintptr) {
+				err = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT, 1)
+			}); controlErr != nil {
+				return controlErr
+			}
+			return err
+		}
+	}
+
+	return lc.Listen(context.Background(), "tcp", addr)
+}
+
+// notifyReady tells the process that handed off to this one that it serves, so it can
+// drain and exit
+func notifyReady() {
+	value := os.Getenv(HANDOFF_READY_ENV)
+	if value == "" {
+		return
+	}
+	os.Unsetenv(HANDOFF_READY_ENV)
+
+	fd, err := strconv.Atoi(value)
+	if err != nil {
+		pkgLogger.Errorf("Invalid %s %q: %v", HANDOFF_READY_ENV, value, err)
+		return
+	}
+
+	ready := os.NewFile(uintptr(fd), "ready")
+	if _, err := ready.Write([]byte{1}); err != nil {
+		pkgLogger.Errorf("Failed to notify the previous process: %v", err)
+	}
+	ready.Close()
+}
+
+// handoff starts a new process of the current binary with the same arguments, passing it
+// the listeners, and waits until it serves. The listeners keep accepting meanwhile, and
+// no connection is refused since the socket stays open in one of the processes.
+func handoff(listeners map[string]net.Listener) error {
+	executable, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to find the executable: %w", err)
+	}
+
+	addrs := make([]string, 0, len(listeners))
+	for addr := range listeners {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+
+	files := make([]*os.File, 0, len(addrs)+1)
+	defer func() {
+		for _, file := range files {
+			file.Close()
+		}
+	}()
+
+	for _, addr := range addrs {
+		tcpListener, ok := listeners[addr].(*net.TCPListener)
+		if !ok {
+			return fmt.Errorf("listener %s cannot be handed off", addr)
+		}
+
+		file, err := tcpListener.File()
+		if err != nil {
+			return fmt.Errorf("failed to hand off listener %s: %w", addr, err)
+		}
+		files = append(files, file)
+	}
+
+	readyR, readyW, err := os.Pipe()
+	if err != nil {
+		return fmt.Errorf("failed to create the ready pipe: %w", err)
+	}
+	defer readyR.Close()
+	files = append(files, readyW)
+
+	cmd := exec.Command(executable, os.Args[1:]...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = files
+	cmd.Env = append(os.Environ(),
+		HANDOFF_LISTENERS_ENV+"="+strings.Join(addrs, ","),
+		HANDOFF_READY_ENV+"="+strconv.Itoa(3+len(addrs)),
+	)
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start the new process: %w", err)
+	}
+	pkgLogger.Infof("Started new process %d, waiting for it to serve", cmd.Process.Pid)
+
+	// Only the new process holds the write end now, so a read ends when it is ready or exits
+	readyW.Close()
+	files = files[:len(files)-1]
+
+	exited := make(chan error, 1)
+	go func() {
+		exited <- cmd.Wait()
+	}()
+
+	ready := make(chan error, 1)
+	go func() {
+		_, err := readyR.Read(make([]byte, 1))
+		ready <- err
+	}()
+
+	select {
+	case err := <-ready:
+		if err == nil {
+			return nil
+		}
+		cmd.Process.Kill()
+		return fmt.Errorf("new process exited before serving: %v", <-exited)
+	case <-time.After(HANDOFF_TIMEOUT):
+		cmd.Process.Kill()
+		return fmt.Errorf("new process did not serve within %s", HANDOFF_TIMEOUT)
+	}
+}
@@ -5,92 +5,271 @@ import (
 	"api-gateway-service-ms/internal/controller"
 	"api-gateway-service-ms/internal/middleware"
 	"api-gateway-service-ms/internal/pkg/cache"
+	"api-gateway-service-ms/internal/pkg/health"
 	"api-gateway-service-ms/internal/pkg/logger"
+	"api-gateway-service-ms/internal/pkg/metrics"
+	"api-gateway-service-ms/internal/pkg/tracing"
+	"api-gateway-service-ms/internal/proxy"
 	"context"
-	"log"
+	"fmt"
 	"os"
+	"reflect"
 
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
 )
 
 var (
-	pkgLogger *logger.Logger
-	pkgCache  *cache.Cache
-	appConfig *config.Config = &config.Config{}
+	pkgLogger     *logger.Logger
+	pkgCache      cache.Storage
+	pkgMetrics    *metrics.Metrics = metrics.New()
+	pkgHealth     *health.Checker
+	configManager *config.Manager
 )
 
-func init() {
-	// init configuration
-	workDir, err := os.Getwd()
+func main() {
+	os.Exit(run(os.Args[1:]))
+}
+
+// setupConfig loads and validates the configuration found in the directory path
+func setupConfig(path string) error {
+	var err error
+	configManager, err = config.NewManager(path)
 	if err != nil {
-		log.Fatalf("Failed to get working directory: %v", err)
+		return fmt.Errorf("failed to load config: %w", err)
 	}
 
-	if err := config.LoadConfig(workDir+"/config", appConfig); err != nil {
-		log.Fatalf("Failed to load config: %v", err)
-	}
+	return nil
+}
 
-	// init logger
+// setupLogger initializes the logger with the given level
+func setupLogger(level logrus.Level) {
 	loggerConfig := logger.LoggerConfig{
 		Env:         "development",
-		Level:       logrus.InfoLevel,
+		Level:       level,
 		ServiceName: "api-gateway",
 		EnableJSON:  false,
 		Fields: map[string]interface{}{
 			"version": "1.0.0",
 		},
+		Redaction: configManager.Get().Logging.Redaction,
+		Sampling:  configManager.Get().Logging.Sampling,
 	}
 	pkgLogger = logger.SetupLogger(loggerConfig)
 
-	// init cache client
-	pkgCache = cache.NewCacheClient(pkgLogger, appConfig)
+	configManager.Subscribe(func(old, new *config.Config) {
+		if old.Logging.Level != new.Logging.Level {
+			setLogLevel(new)
+		}
+
+		if !reflect.DeepEqual(old.Logging.Sampling, new.Logging.Sampling) {
+			pkgLogger.SetSampling(new.Logging.Sampling)
+		}
+
+		if reflect.DeepEqual(old.Logging.Redaction, new.Logging.Redaction) {
+			return
+		}
+
+		if err := pkgLogger.SetRedaction(new.Logging.Redaction); err != nil {
+			pkgLogger.Errorf("Failed to apply redaction settings: %v", err)
+		}
+	})
+}
+
+// setLogLevel applies logging.level, or the default level of the environment
+func setLogLevel(cfg *config.Config) {
+	level := logrus.InfoLevel
+	if cfg.Env == "development" {
+		level = logrus.DebugLevel
+	}
+
+	if cfg.Logging.Level != "" {
+		parsed, err := logrus.ParseLevel(cfg.Logging.Level)
+		if err != nil {
+			pkgLogger.Errorf("Invalid log level %q: %v", cfg.Logging.Level, err)
+			return
+		}
+		level = parsed
+	}
+
+	pkgLogger.SetLevel(level)
+}
+
+// setupCache connects to the configured cache
+func setupCache() error {
+	var err error
+	pkgCache, err = cache.NewStorage(pkgLogger, configManager.Get())
+	if err != nil {
+		return fmt.Errorf("failed to create cache client: %w", err)
+	}
+
+	// Only the Redis driver sends commands worth observing
+	if redisCache, ok := pkgCache.(*cache.Cache); ok {
+		redisCache.AddHook(pkgMetrics.RedisHook())
+		redisCache.AddHook(tracing.RedisHook())
+	}
+
 	if err := pkgCache.Ping(context.Background()); err != nil {
-		log.Fatalf("Failed to ping cache: %v", err)
+		return fmt.Errorf("failed to ping cache: %w", err)
 	}
+
+	return nil
 }
 
-func main() {
+// serve starts the gateway and blocks until it shuts down
+func serve() error {
+	appConfig := configManager.Get()
 	logger.SetConfig(appConfig.Env)
-	if appConfig.Env == "development" {
-		logger.SetLevel(logrus.DebugLevel)
-	} else if appConfig.Env == "production" {
+	setLogLevel(appConfig)
+	if appConfig.Env == "production" {
 		gin.SetMode(gin.ReleaseMode)
 	}
 
+	// reload the configuration on file changes and SIGHUP
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err := configManager.Watch(ctx, onConfigReload); err != nil {
+		pkgLogger.Warnf("Config hot reload disabled: %v", err)
+	}
+
+	shutdownTracing, err := tracing.Setup(ctx, appConfig.Tracing)
+	if err != nil {
+		return fmt.Errorf("failed to setup tracing: %w", err)
+	}
+	defer func() {
+		// Flush the spans still buffered by the exporter
+		if err := shutdownTracing(context.Background()); err != nil {
+			pkgLogger.Errorf("Failed to flush traces: %v", err)
+		}
+	}()
+
+	router := newRouter()
+
+	// Check the dependencies in the background for /readyz and /health
+	go pkgHealth.Run(ctx)
+
+	// Start the server
+	return StartHTTPServer(router)
+}
+
+// newRouter builds the gateway router with its middlewares, routes and proxy
+func newRouter() *gin.Engine {
 	// init the router
 	router := gin.New()
 
 	// init the middleware
 	loggerMiddleware := middleware.NewLoggerMiddleware(pkgLogger)
-	authMiddleware := middleware.NewAuthMiddleware(appConfig, pkgLogger)
-	idempotencyMiddleware := middleware.NewIdempotencyMiddleware(pkgCache, pkgLogger)
-	rateLimiterMiddleware := middleware.NewRateLimiterMiddleware(pkgCache, pkgLogger, appConfig)
+	authMiddleware := middleware.NewAuthMiddleware(configManager, pkgLogger)
+	idempotencyMiddleware := middleware.NewIdempotencyMiddleware(pkgCache, pkgLogger, configManager, pkgMetrics)
+	rateLimiterMiddleware := middleware.NewRateLimiterMiddleware(pkgCache, pkgLogger, configManager, pkgMetrics)
+	priorityMiddleware := middleware.NewPriorityMiddleware(configManager, pkgLogger, pkgMetrics)
+	responseCacheMiddleware := middleware.NewResponseCacheMiddleware(pkgCache, pkgLogger, configManager)
+	metricsMiddleware := middleware.NewMetricsMiddleware(configManager, pkgMetrics)
+	tracingMiddleware := middleware.NewTracingMiddleware(configManager)
+	accessLogMiddleware := middleware.NewAccessLogMiddleware(configManager, pkgLogger)
+	captureMiddleware := middleware.NewCaptureMiddleware(configManager, pkgLogger)
 	middleware := middleware.NewMiddleware(
 		rateLimiterMiddleware,
 		loggerMiddleware,
 		authMiddleware,
 		idempotencyMiddleware,
+		priorityMiddleware,
+		responseCacheMiddleware,
+		metricsMiddleware,
+		tracingMiddleware,
+		accessLogMiddleware,
+		captureMiddleware,
+		middleware.NewListenerMiddleware(configManager),
 	)
 
 	// init the controller
-	healthController := controller.NewHealthController(appConfig, pkgCache, pkgLogger)
+	pkgHealth = health.NewChecker(configManager, pkgCache, pkgLogger)
+	healthController := controller.NewHealthController(pkgHealth, pkgLogger)
+	priorityController := controller.NewPriorityController(priorityMiddleware, pkgLogger)
+	cacheController := controller.NewCacheController(responseCacheMiddleware, pkgLogger)
+	metricsController := controller.NewMetricsController(configManager, pkgMetrics)
+	logController := controller.NewLogController(pkgLogger)
+	captureController := controller.NewCaptureController(captureMiddleware, pkgLogger)
+
+	// The probes are registered before the middleware, so frequent probing is never
+	// rate limited, logged or traced
+	router.GET("/livez", middleware.Listener(config.ROUTES_PROBES), healthController.Live)
+	router.GET("/readyz", middleware.Listener(config.ROUTES_PROBES), healthController.Ready)
 
 	// Register the middleware
+	router.Use(middleware.Tracing())
+	router.Use(middleware.AccessLog())
+	router.Use(middleware.Metrics())
+	// The verified user keys the logging, capture, rate limiting, idempotency, caching
+	// and prioritization of the requests that follow
+	router.Use(middleware.Identity())
 	router.Use(middleware.Logger())
+	router.Use(middleware.Capture())
 	router.Use(middleware.RateLimiter())
 	router.Use(middleware.Idempotency())
+	router.Use(middleware.ResponseCache())
+	router.Use(middleware.Priority())
 
-	// regi the routes
-	healthRouter := router.Group("/health")
+	// regi the routes, each group served on the listeners it is attached to
+	healthRouter := router.Group("/health", middleware.Listener(config.ROUTES_HEALTH), middleware.Authentication())
 	healthRouter.GET("", healthController.CheckHealth)
 
+	priorityRouter := router.Group("/priority", middleware.Listener(config.ROUTES_PRIORITY), middleware.Authentication(), middleware.Admin())
+	priorityRouter.GET("/stats", priorityController.GetStats)
+
+	cacheRouter := router.Group("/cache", middleware.Listener(config.ROUTES_CACHE), middleware.Authentication(), middleware.Admin())
+	cacheRouter.DELETE("", cacheController.Purge)
+
+	metricsRouter := router.Group("/metrics", middleware.Listener(config.ROUTES_METRICS))
+	metricsRouter.GET("", metricsController.GetMetrics)
+
+	adminRouter := router.Group("/admin", middleware.Listener(config.ROUTES_ADMIN), middleware.Authentication())
+	logRouter := adminRouter.Group("/log-level", middleware.Admin())
+	logRouter.GET("", logController.GetLevel)
+	logRouter.PUT("", logController.SetLevel)
+	logRouter.POST("/debug", logController.AddDebugRule)
+	logRouter.DELETE("/debug", logController.ClearDebugRules)
+	captureRouter := adminRouter.Group("/captures", middleware.Admin())
+	captureRouter.GET("", captureController.GetCaptures)
+	captureRouter.DELETE("", captureController.ClearCaptures)
+	captureRouter.GET("/rules", captureController.GetRules)
+	captureRouter.POST("/rules", captureController.AddRule)
+	captureRouter.DELETE("/rules/:id", captureController.DeleteRule)
+
 	// register the proxy
-	// proxy := proxy.NewServiceProxy(appConfig, pkgLogger)
-	// proxy.SetupRoutes(router)
+	proxy := proxy.NewServiceProxy(configManager, pkgLogger, pkgMetrics)
+	proxy.SetupRoutes(router, middleware.Listener(config.ROUTES_PROXY))
+	responseCacheMiddleware.SetHandler(proxy.HandleRequest())
 
-	// Start the server
-	if err := StartHTTPServer(router); err != nil {
-		pkgLogger.Fatalf("Failed to start the server: %v", err)
+	return router
+}
+
+// onConfigReload logs the outcome of a configuration reload
+func onConfigReload(changes []string, err error) {
+	if err != nil {
+		pkgLogger.Errorf("Config reload failed, keeping the current configuration: %v", err)
+		return
+	}
+
+	if len(changes) == 0 {
+		return
+	}
+
+	pkgLogger.Infof("Config reloaded with %d changes", len(changes))
+	for _, change := range changes {
+		pkgLogger.Infof("Config changed %s", change)
+	}
+
+	// The listener, cache client, logger and capture buffer are built once at startup, and the
+	// recording file is opened once on the first recorded exchange
+	if config.Changed(changes, "env", "server", "cache", "capture.buffer_size", "capture.retention", "capture.file",
+		"recording.path", "recording.max_size", "recording.max_backups") {
+		pkgLogger.Warnf("Changes to env, server, cache, capture storage or recording file settings take effect after a restart")
+	}
+
+	// The tracer provider is installed once at startup
+	if config.Changed(changes, "tracing") {
+		pkgLogger.Warnf("Changes to tracing settings take effect after a restart")
 	}
 }
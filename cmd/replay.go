@@ -0,0 +1,151 @@
+package main
+
+import (
+	"api-gateway-service-ms/internal/pkg/recording"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// stringsFlag collects a flag given several times
+type stringsFlag []string
+
+func (f *stringsFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *stringsFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+const replayUsage = `Usage: api-gateway replay --file <recording> --target <url> [flags]
+
+Sends the recorded requests again and reports the responses whose status or body
+changed. Values are masked in recordings like in the logs, so requests whose body was
+masked, or with masked headers not set again with --header, are reported as not
+replayable instead of being sent altered.
+
+Flags:
+`
+
+// replayCommand sends the requests of a recording to a gateway or backend and reports
+// the responses that differ from the recorded ones
+func replayCommand(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("replay", flag.ContinueOnError)
+	file := fs.String("file", "", "recording to replay, as written by recording.path")
+	target := fs.String("target", "", "base URL of the gateway or backend, e.g. http://localhost:8080")
+	rate := fs.Float64("rate", 0, "maximum requests per second, unlimited when 0")
+	concurrency := fs.Int("concurrency", 1, "number of requests in flight")
+	stripService := fs.Bool("strip-service", false, "remove the service prefix from paths, to replay against a backend")
+	timeout := fs.Duration("timeout", 30*time.Second, "timeout of each request")
+	verbose := fs.Bool("verbose", false, "also list the matching requests")
+	var headers, services, ignoreFields stringsFlag
+	fs.Var(&headers, "header", `"Name: value" set on every request, "Name:" removes it; repeatable`)
+	fs.Var(&services, "service", "only replay the requests of this service; repeatable")
+	fs.Var(&ignoreFields, "ignore-field", `JSON path left out of body comparisons, e.g. "meta.timestamp"; repeatable`)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), replayUsage)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *file == "" || *target == "" {
+		return errors.New("--file and --target are required")
+	}
+
+	targetURL, err := url.Parse(*target)
+	if err != nil || targetURL.Scheme == "" || targetURL.Host == "" {
+		return fmt.Errorf("--target must be an absolute URL, got %q", *target)
+	}
+
+	header := http.Header{}
+	for _, h := range headers {
+		name, value, ok := strings.Cut(h, ":")
+		if !ok || strings.TrimSpace(name) == "" {
+			return fmt.Errorf(`--header must be "Name: value", got %q`, h)
+		}
+		header.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+
+	f, err := os.Open(*file)
+	if err != nil {
+		return fmt.Errorf("failed to open recording: %w", err)
+	}
+	exchanges, err := recording.Read(f)
+	f.Close()
+	if err != nil {
+		return err
+	}
+
+	// Stop sending on Ctrl-C and report what was replayed so far
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	report := recording.Replay(ctx, exchanges, recording.ReplayOptions{
+		Target:       targetURL,
+		StripService: *stripService,
+		Services:     services,
+		Rate:         *rate,
+		Concurrency:  *concurrency,
+		Headers:      header,
+		IgnoreFields: ignoreFields,
+		Client: &http.Client{
+			Timeout: *timeout,
+			// The recorded response is compared, not the one redirected to
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	})
+
+	if err := printReport(stdout, report, *verbose); err != nil {
+		return err
+	}
+
+	if report.StatusMismatches+report.BodyMismatches+report.Errors > 0 {
+		return fmt.Errorf("%d of %d replayed requests differ from the recording",
+			report.StatusMismatches+report.BodyMismatches+report.Errors, report.Total)
+	}
+
+	return nil
+}
+
+// printReport prints a line per differing request, with its body differences, and the totals
+func printReport(stdout io.Writer, report *recording.Report, verbose bool) error {
+	for _, result := range report.Results {
+		exchange := result.Exchange
+		request := exchange.Method + " " + exchange.URL
+		switch {
+		case result.Err != nil:
+			fmt.Fprintf(stdout, "ERROR   %s: %v\n", request, result.Err)
+		case result.NotReplayable != "":
+			fmt.Fprintf(stdout, "SKIPPED %s: %s\n", request, result.NotReplayable)
+		case result.Status != exchange.Status:
+			fmt.Fprintf(stdout, "STATUS  %s: %d recorded, %d replayed\n", request, exchange.Status, result.Status)
+		case len(result.BodyDiffs) > 0:
+			fmt.Fprintf(stdout, "BODY    %s: %d\n", request, result.Status)
+			for _, diff := range result.BodyDiffs {
+				fmt.Fprintf(stdout, "          %s\n", diff)
+			}
+		case verbose:
+			fmt.Fprintf(stdout, "OK      %s: %d in %s\n", request, result.Status, result.Duration.Round(time.Millisecond))
+		}
+	}
+
+	matched := report.Total - report.StatusMismatches - report.BodyMismatches - report.Errors - report.NotReplayable
+	_, err := fmt.Fprintf(stdout, "\n%d replayed: %d matched, %d status mismatches, %d body mismatches, %d errors, %d not replayable\n",
+		report.Total, matched, report.StatusMismatches, report.BodyMismatches, report.Errors, report.NotReplayable)
+	return err
+}
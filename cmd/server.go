@@ -1,73 +1,286 @@
 package main
 
 import (
+	"api-gateway-service-ms/config"
+	"api-gateway-service-ms/internal/middleware"
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
-// StartHTTPServer starts the HTTP server with graceful shutdown support
+const (
+	DEFAULT_LISTENER      = "default"
+	DEFAULT_READ_TIMEOUT  = 15 * time.Second
+	DEFAULT_WRITE_TIMEOUT = 15 * time.Second
+	DEFAULT_IDLE_TIMEOUT  = 60 * time.Second
+
+	DEFAULT_SHUTDOWN_TIMEOUT = 10 * time.Second
+	// SHUTDOWN_POLL_INTERVAL is how often the requests in flight are counted while draining
+	SHUTDOWN_POLL_INTERVAL = 100 * time.Millisecond
+)
+
+// StartHTTPServer serves the router on the configured listeners until SIGINT or SIGTERM,
+// then drains them. On SIGUSR2 a new process of the same binary takes over the listeners
+// before this one drains.
 func StartHTTPServer(router *gin.Engine) error {
-	// Configure server
-	addr := fmt.Sprintf("%s:%s", appConfig.Server.Host, appConfig.Server.Port)
-	srv := &http.Server{
-		Addr:         addr,
-		Handler:      router,
-		ReadTimeout:  15 * time.Second,
-		WriteTimeout: 15 * time.Second,
-		IdleTimeout:  60 * time.Second,
+	appConfig := configManager.Get()
+	listenerConfigs := listeners(appConfig.Server)
+
+	inherited, err := inheritedListeners()
+	if err != nil {
+		return err
 	}
 
-	// Create shutdown channel with buffer
-	shutdownChan := make(chan error, 1)
+	// Canceling the base context ends the requests left when the shutdown budget is
+	// exceeded, including upgraded connections the server no longer tracks
+	baseCtx, cancelRequests := context.WithCancel(context.Background())
+	defer cancelRequests()
 
-	// Start server in a goroutine
-	go func() {
-		pkgLogger.Infof("Starting server on %s", addr)
+	handler := &inflightHandler{next: router}
+	servers := make([]*http.Server, 0, len(listenerConfigs))
+	bound := make(map[string]net.Listener, len(listenerConfigs))
+	shutdownChan := make(chan error, len(listenerConfigs))
 
-		var err error
-		if appConfig.Server.TLS.Enable {
-			err = srv.ListenAndServeTLS(appConfig.Server.TLS.CertFile, appConfig.Server.TLS.KeyFile)
-		} else {
-			err = srv.ListenAndServe()
+	for _, listenerConfig := range listenerConfigs {
+		addr := fmt.Sprintf("%s:%s", listenerConfig.Host, listenerConfig.Port)
+		ln, err := listen(addr, appConfig.Server.ReusePort, inherited)
+		if err != nil {
+			for _, ln := range bound {
+				ln.Close()
+			}
+			return fmt.Errorf("failed to listen on %s for listener %s: %w", addr, listenerConfig.Name, err)
 		}
+		bound[addr] = ln
 
-		if err != nil && !errors.Is(err, http.ErrServerClosed) {
-			shutdownChan <- fmt.Errorf("server error: %w", err)
-		}
-	}()
+		srv := newServer(listenerConfig, appConfig.Server, handler, baseCtx)
+		servers = append(servers, srv)
 
-	// Listen for interrupt signal or shutdown channel
+		// Start server in a goroutine
+		go func() {
+			pkgLogger.Infof("Starting listener %s on %s", listenerConfig.Name, addr)
+
+			var err error
+			if listenerConfig.TLS.Enable {
+				err = srv.ServeTLS(ln, listenerConfig.TLS.CertFile, listenerConfig.TLS.KeyFile)
+			} else {
+				err = srv.Serve(ln)
+			}
+
+			if err != nil && !errors.Is(err, http.ErrServerClosed) {
+				shutdownChan <- fmt.Errorf("listener %s error: %w", listenerConfig.Name, err)
+			}
+		}()
+	}
+
+	// Listeners no longer configured after a handoff
+	for addr, ln := range inherited {
+		pkgLogger.Warnf("Closing inherited listener on %s, no longer configured", addr)
+		ln.Close()
+	}
+	notifyReady()
+
+	// Listen for shutdown and handoff signals
 	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM, syscall.SIGUSR2)
+	defer signal.Stop(quit)
 
 	// Block until we receive our signal or an error
-	select {
-	case <-quit:
-		pkgLogger.Info("Shutdown signal received")
-	case err := <-shutdownChan:
-		pkgLogger.Errorf("Server error: %v", err)
-		return err
+	for {
+		select {
+		case sig := <-quit:
+			if sig != syscall.SIGUSR2 {
+				pkgLogger.Info("Shutdown signal received")
+				return shutdown(servers, handler, cancelRequests, quit, true)
+			}
+
+			pkgLogger.Info("Handoff signal received")
+			if err := handoff(bound); err != nil {
+				pkgLogger.Errorf("Handoff failed, still serving: %v", err)
+				continue
+			}
+
+			// The new process accepts on the same sockets, so load balancers need no delay
+			pkgLogger.Info("Listeners handed off to the new process")
+			return shutdown(servers, handler, cancelRequests, quit, false)
+		case err := <-shutdownChan:
+			pkgLogger.Errorf("Server error: %v", err)
+			return err
+		}
+	}
+}
+
+// listeners returns server.listeners, or a single listener named "default" from server.host,
+// server.port and server.tls serving all the routes
+func listeners(cfg config.ServerConfig) []config.ListenerConfig {
+	if len(cfg.Listeners) > 0 {
+		return cfg.Listeners
+	}
+
+	return []config.ListenerConfig{{
+		Name: DEFAULT_LISTENER,
+		Host: cfg.Host,
+		Port: cfg.Port,
+		TLS:  cfg.TLS,
+	}}
+}
+
+// newServer configures the server of a listener. Requests carry the listener name, so the
+// route groups not attached to it answer 404; redirect listeners only redirect to HTTPS.
+func newServer(listenerConfig config.ListenerConfig, serverConfig config.ServerConfig, handler http.Handler, baseCtx context.Context) *http.Server {
+	for _, target := range serverConfig.Listeners {
+		if listenerConfig.Redirect != "" && target.Name == listenerConfig.Redirect {
+			handler = redirectHandler(target.Port)
+			break
+		}
+	}
+
+	srv := &http.Server{
+		Handler:           handler,
+		ReadTimeout:       durationOr(listenerConfig.ReadTimeout, DEFAULT_READ_TIMEOUT),
+		ReadHeaderTimeout: listenerConfig.ReadHeaderTimeout,
+		WriteTimeout:      durationOr(listenerConfig.WriteTimeout, DEFAULT_WRITE_TIMEOUT),
+		IdleTimeout:       durationOr(listenerConfig.IdleTimeout, DEFAULT_IDLE_TIMEOUT),
+		BaseContext: func(net.Listener) context.Context {
+			return middleware.WithListener(baseCtx, listenerConfig.Name)
+		},
+	}
+
+	switch listenerConfig.TLS.MinVersion {
+	case "1.3":
+		srv.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS13}
+	default:
+		srv.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+
+	return srv
+}
+
+// redirectHandler redirects every request to the same URL over HTTPS on port
+func redirectHandler(port string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if port != "443" {
+			host = net.JoinHostPort(host, port)
+		}
+
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+	})
+}
+
+func durationOr(d, fallback time.Duration) time.Duration {
+	if d > 0 {
+		return d
 	}
 
-	// Create shutdown timeout context
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	return fallback
+}
+
+// shutdown fails readiness, waits the pre-stop delay, stops accepting connections and
+// waits for the requests in flight within the shutdown budget, then closes the rest. A
+// second signal skips the waits.
+func shutdown(servers []*http.Server, handler *inflightHandler, cancelRequests context.CancelFunc, quit <-chan os.Signal, preStop bool) error {
+	cfg := configManager.Get().Shutdown
+
+	// Fail readiness so load balancers stop sending new requests
+	pkgHealth.SetDraining(true)
+
+	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
+	go func() {
+		select {
+		case <-quit:
+			pkgLogger.Warn("Second signal received, closing connections now")
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	if preStop && cfg.PreStopDelay > 0 {
+		pkgLogger.Infof("Waiting %s for load balancers before closing the listeners", cfg.PreStopDelay)
+		select {
+		case <-time.After(cfg.PreStopDelay):
+		case <-ctx.Done():
+		}
+	}
+
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = DEFAULT_SHUTDOWN_TIMEOUT
+	}
+	ctx, cancelTimeout := context.WithTimeout(ctx, timeout)
+	defer cancelTimeout()
 
-	// Attempt graceful shutdown
+	// Attempt graceful shutdown, then wait for the upgraded and streaming connections
 	pkgLogger.Info("Shutting down server gracefully...")
-	if err := srv.Shutdown(ctx); err != nil {
-		pkgLogger.Errorf("Forced shutdown: %v", err)
+	errs := make([]error, len(servers))
+	var wg sync.WaitGroup
+	for i, srv := range servers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = srv.Shutdown(ctx)
+		}()
+	}
+	wg.Wait()
+
+	err := errors.Join(errs...)
+	if err == nil {
+		err = handler.wait(ctx)
+	}
+
+	if err != nil {
+		pkgLogger.Errorf("Forced shutdown with %d requests in flight: %v", handler.inflight.Load(), err)
+		cancelRequests()
+		for _, srv := range servers {
+			srv.Close()
+		}
 		return err
 	}
 
 	pkgLogger.Info("Server stopped successfully")
 	return nil
 }
+
+// inflightHandler counts the requests being served, including upgraded connections
+// http.Server.Shutdown doesn't wait for
+type inflightHandler struct {
+	next     http.Handler
+	inflight atomic.Int64
+}
+
+func (h *inflightHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.inflight.Add(1)
+	defer h.inflight.Add(-1)
+
+	h.next.ServeHTTP(w, r)
+}
+
+// wait returns once no request is in flight, or ctx's error when it is done first
+func (h *inflightHandler) wait(ctx context.Context) error {
+	ticker := time.NewTicker(SHUTDOWN_POLL_INTERVAL)
+	defer ticker.Stop()
+
+	for h.inflight.Load() > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+
+	return nil
+}
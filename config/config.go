@@ -1,35 +1,125 @@
 package config
 
-import "time"
+import (
+	"slices"
+	"time"
+)
 
 type Config struct {
-	Env              string            `yaml:"env" mapstructure:"env"`
-	Server           ServerConfig      `yaml:"server" mapstructure:"server"`
-	Cache            CacheConfig       `yaml:"cache" mapstructure:"cache"`
-	Auth             AuthConfig        `yaml:"auth" mapstructure:"auth"`
-	Ratelimit        RatelimitConfig   `yaml:"ratelimit" mapstructure:"ratelimit"`
-	FowardServiceUrl map[string]string `yaml:"forward_service_url" mapstructure:"forward_service_url"`
+	Env              string              `yaml:"env" mapstructure:"env"`
+	Server           ServerConfig        `yaml:"server" mapstructure:"server"`
+	Cache            CacheConfig         `yaml:"cache" mapstructure:"cache"`
+	Auth             AuthConfig          `yaml:"auth" mapstructure:"auth"`
+	Ratelimit        RatelimitConfig     `yaml:"ratelimit" mapstructure:"ratelimit"`
+	Priority         PriorityConfig      `yaml:"priority" mapstructure:"priority"`
+	Idempotency      IdempotencyConfig   `yaml:"idempotency" mapstructure:"idempotency"`
+	ResponseCache    ResponseCacheConfig `yaml:"response_cache" mapstructure:"response_cache"`
+	Coalescing       CoalescingConfig    `yaml:"coalescing" mapstructure:"coalescing"`
+	Metrics          MetricsConfig       `yaml:"metrics" mapstructure:"metrics"`
+	Tracing          TracingConfig       `yaml:"tracing" mapstructure:"tracing"`
+	AccessLog        AccessLogConfig     `yaml:"access_log" mapstructure:"access_log"`
+	Logging          LoggingConfig       `yaml:"logging" mapstructure:"logging"`
+	Capture          CaptureConfig       `yaml:"capture" mapstructure:"capture"`
+	Recording        RecordingConfig     `yaml:"recording" mapstructure:"recording"`
+	Health           HealthConfig        `yaml:"health" mapstructure:"health"`
+	Shutdown         ShutdownConfig      `yaml:"shutdown" mapstructure:"shutdown"`
+	FowardServiceUrl map[string]string   `yaml:"forward_service_url" mapstructure:"forward_service_url"`
 }
 
 type ServerConfig struct {
 	Host string `yaml:"host" mapstructure:"host"`
 	Port string `yaml:"port" mapstructure:"port"`
-	TLS  struct {
-		Enable   bool   `yaml:"enable" mapstructure:"enable"`
-		CertFile string `yaml:"cert_file" mapstructure:"cert_file"`
-		KeyFile  string `yaml:"key_file" mapstructure:"key_file"`
-	} `yaml:"tls" mapstructure:"tls"`
+	// ReusePort binds with SO_REUSEPORT, so a new process can listen on the port before
+	// this one stops
+	ReusePort bool      `yaml:"reuse_port" mapstructure:"reuse_port"`
+	TLS       TLSConfig `yaml:"tls" mapstructure:"tls"`
+	// Listeners replace host, port and tls with several named listeners, e.g. public,
+	// internal and admin ports
+	Listeners []ListenerConfig `yaml:"listeners" mapstructure:"listeners"`
+}
+
+type TLSConfig struct {
+	Enable   bool   `yaml:"enable" mapstructure:"enable"`
+	CertFile string `yaml:"cert_file" mapstructure:"cert_file"`
+	KeyFile  string `yaml:"key_file" mapstructure:"key_file"`
+	// MinVersion is the lowest TLS version accepted: 1.2 (default) or 1.3
+	MinVersion string `yaml:"min_version" mapstructure:"min_version"`
+}
+
+// Route groups attached to listeners with server.listeners[].routes
+const (
+	ROUTES_PROBES   = "probes"
+	ROUTES_HEALTH   = "health"
+	ROUTES_METRICS  = "metrics"
+	ROUTES_PRIORITY = "priority"
+	ROUTES_CACHE    = "cache"
+	ROUTES_ADMIN    = "admin"
+	ROUTES_PROXY    = "proxy"
+)
+
+// ListenerConfig is an address the gateway listens on, serving some or all of its routes
+type ListenerConfig struct {
+	Name string    `yaml:"name" mapstructure:"name"`
+	Host string    `yaml:"host" mapstructure:"host"`
+	Port string    `yaml:"port" mapstructure:"port"`
+	TLS  TLSConfig `yaml:"tls" mapstructure:"tls"`
+	// ReadTimeout and WriteTimeout default to 15 seconds, IdleTimeout to 60 seconds
+	ReadTimeout       time.Duration `yaml:"read_timeout" mapstructure:"read_timeout"`
+	ReadHeaderTimeout time.Duration `yaml:"read_header_timeout" mapstructure:"read_header_timeout"`
+	WriteTimeout      time.Duration `yaml:"write_timeout" mapstructure:"write_timeout"`
+	IdleTimeout       time.Duration `yaml:"idle_timeout" mapstructure:"idle_timeout"`
+	// Routes are the route groups served: probes, health, metrics, priority, cache, admin
+	// and proxy; all but metrics and priority when empty
+	Routes []string `yaml:"routes" mapstructure:"routes"`
+	// Redirect answers every request with a redirect to the HTTPS listener of this name
+	Redirect string `yaml:"redirect" mapstructure:"redirect"`
+}
+
+// Serves reports whether the listener serves the route group. The internal metrics and
+// priority groups are only served on the listeners listing them.
+func (l ListenerConfig) Serves(group string) bool {
+	if len(l.Routes) == 0 {
+		return group != ROUTES_METRICS && group != ROUTES_PRIORITY
+	}
+
+	return slices.Contains(l.Routes, group)
 }
 
 type CacheConfig struct {
+	// Driver selects the storage backend: redis (default) or memory
+	Driver string `yaml:"driver" mapstructure:"driver"`
+	// Mode selects the Redis topology: standalone (default), sentinel or cluster
+	Mode     string `yaml:"mode" mapstructure:"mode"`
 	Host     string `yaml:"host" mapstructure:"host"`
 	Port     string `yaml:"port" mapstructure:"port"`
-	Password string `yaml:"password" mapstructure:"password"`
+	User     string `yaml:"user" mapstructure:"user"`
+	Password string `yaml:"password" mapstructure:"password" secret:"true"`
 	DB       int    `yaml:"db" mapstructure:"db"`
+	// Addrs are the sentinel or cluster seed addresses, host and port are used when empty
+	Addrs            []string       `yaml:"addrs" mapstructure:"addrs"`
+	MasterName       string         `yaml:"master_name" mapstructure:"master_name"`
+	SentinelUser     string         `yaml:"sentinel_user" mapstructure:"sentinel_user"`
+	SentinelPassword string         `yaml:"sentinel_password" mapstructure:"sentinel_password" secret:"true"`
+	PoolSize         int            `yaml:"pool_size" mapstructure:"pool_size"`
+	MinIdleConns     int            `yaml:"min_idle_conns" mapstructure:"min_idle_conns"`
+	PoolTimeout      time.Duration  `yaml:"pool_timeout" mapstructure:"pool_timeout"`
+	DialTimeout      time.Duration  `yaml:"dial_timeout" mapstructure:"dial_timeout"`
+	ReadTimeout      time.Duration  `yaml:"read_timeout" mapstructure:"read_timeout"`
+	WriteTimeout     time.Duration  `yaml:"write_timeout" mapstructure:"write_timeout"`
+	TLS              CacheTLSConfig `yaml:"tls" mapstructure:"tls"`
+}
+
+type CacheTLSConfig struct {
+	Enable             bool   `yaml:"enable" mapstructure:"enable"`
+	CAFile             string `yaml:"ca_file" mapstructure:"ca_file"`
+	CertFile           string `yaml:"cert_file" mapstructure:"cert_file"`
+	KeyFile            string `yaml:"key_file" mapstructure:"key_file"`
+	ServerName         string `yaml:"server_name" mapstructure:"server_name"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify" mapstructure:"insecure_skip_verify"`
 }
 
 type AuthConfig struct {
-	JWTSecret     string        `yaml:"jwt_secret" mapstructure:"jwt_secret"`
+	JWTSecret     string        `yaml:"jwt_secret" mapstructure:"jwt_secret" secret:"true"`
 	JWTExpiration time.Duration `yaml:"jwt_expiration" mapstructure:"jwt_expiration"`
 }
 
@@ -37,4 +127,243 @@ type RatelimitConfig struct {
 	Limit   int           `yaml:"limit" mapstructure:"limit"`
 	Period  time.Duration `yaml:"period" mapstructure:"period"`
 	Enabled bool          `yaml:"enabled" mapstructure:"enabled"`
+	// Headers selects the rate limit headers sent to clients: legacy, ietf or both
+	Headers string `yaml:"headers" mapstructure:"headers"`
+}
+
+type IdempotencyConfig struct {
+	// Mode is the default policy for routes without an override: disabled, required, optional or auto
+	Mode    string        `yaml:"mode" mapstructure:"mode"`
+	Header  string        `yaml:"header" mapstructure:"header"`
+	LockTTL time.Duration `yaml:"lock_ttl" mapstructure:"lock_ttl"`
+	// ConcurrentPolicy decides what happens to duplicates of an in-flight request: reject or wait
+	ConcurrentPolicy  string                   `yaml:"concurrent_policy" mapstructure:"concurrent_policy"`
+	WaitTimeout       time.Duration            `yaml:"wait_timeout" mapstructure:"wait_timeout"`
+	TTL               time.Duration            `yaml:"ttl" mapstructure:"ttl"`
+	MaxBodySize       int64                    `yaml:"max_body_size" mapstructure:"max_body_size"`
+	CacheClientErrors bool                     `yaml:"cache_client_errors" mapstructure:"cache_client_errors"`
+	Routes            []IdempotencyRouteConfig `yaml:"routes" mapstructure:"routes"`
+}
+
+// IdempotencyRouteConfig overrides the idempotency settings for a path prefix
+type IdempotencyRouteConfig struct {
+	Path              string        `yaml:"path" mapstructure:"path"`
+	Mode              string        `yaml:"mode" mapstructure:"mode"`
+	TTL               time.Duration `yaml:"ttl" mapstructure:"ttl"`
+	CacheClientErrors *bool         `yaml:"cache_client_errors" mapstructure:"cache_client_errors"`
+	// Window is how long auto-derived keys deduplicate requests
+	Window time.Duration `yaml:"window" mapstructure:"window"`
+	// KeyHeaders and KeyBodyFields select the request parts used to derive keys in auto mode
+	KeyHeaders    []string `yaml:"key_headers" mapstructure:"key_headers"`
+	KeyBodyFields []string `yaml:"key_body_fields" mapstructure:"key_body_fields"`
+}
+
+type ResponseCacheConfig struct {
+	Enabled bool `yaml:"enabled" mapstructure:"enabled"`
+	// MaxBodySize skips storing responses larger than this many bytes
+	MaxBodySize int64 `yaml:"max_body_size" mapstructure:"max_body_size"`
+	// StaleWhileRevalidate and StaleIfError apply when the upstream doesn't send the directives,
+	// and only to responses with an explicit freshness lifetime
+	StaleWhileRevalidate time.Duration              `yaml:"stale_while_revalidate" mapstructure:"stale_while_revalidate"`
+	StaleIfError         time.Duration              `yaml:"stale_if_error" mapstructure:"stale_if_error"`
+	TagHeader            string                     `yaml:"tag_header" mapstructure:"tag_header"`
+	Routes               []ResponseCacheRouteConfig `yaml:"routes" mapstructure:"routes"`
+}
+
+// ResponseCacheRouteConfig overrides the response cache settings for a path prefix
+type ResponseCacheRouteConfig struct {
+	Path     string `yaml:"path" mapstructure:"path"`
+	Disabled bool   `yaml:"disabled" mapstructure:"disabled"`
+	// TTL overrides the freshness lifetime sent by the upstream
+	TTL                  time.Duration `yaml:"ttl" mapstructure:"ttl"`
+	StaleWhileRevalidate time.Duration `yaml:"stale_while_revalidate" mapstructure:"stale_while_revalidate"`
+	StaleIfError         time.Duration `yaml:"stale_if_error" mapstructure:"stale_if_error"`
+	// KeyHeaders are request headers added to the cache key
+	KeyHeaders []string `yaml:"key_headers" mapstructure:"key_headers"`
+	// PerUser stores private responses per authenticated user
+	PerUser      bool   `yaml:"per_user" mapstructure:"per_user"`
+	TenantHeader string `yaml:"tenant_header" mapstructure:"tenant_header"`
+}
+
+// CoalescingConfig collapses concurrent identical GET requests into one upstream call
+type CoalescingConfig struct {
+	Enabled bool          `yaml:"enabled" mapstructure:"enabled"`
+	MaxWait time.Duration `yaml:"max_wait" mapstructure:"max_wait"`
+	// MaxBodySize is the largest response shared, larger ones are streamed to the first caller
+	MaxBodySize int64                   `yaml:"max_body_size" mapstructure:"max_body_size"`
+	Routes      []CoalescingRouteConfig `yaml:"routes" mapstructure:"routes"`
+}
+
+// CoalescingRouteConfig overrides the coalescing settings for a path prefix
+type CoalescingRouteConfig struct {
+	Path    string        `yaml:"path" mapstructure:"path"`
+	Enabled bool          `yaml:"enabled" mapstructure:"enabled"`
+	MaxWait time.Duration `yaml:"max_wait" mapstructure:"max_wait"`
+}
+
+// ShutdownConfig is the sequence run on SIGINT or SIGTERM: fail readiness, wait
+// PreStopDelay, stop accepting connections, wait up to Timeout for the requests in
+// flight, then close the remaining connections
+type ShutdownConfig struct {
+	// PreStopDelay keeps serving after readiness fails, until load balancers stop sending traffic
+	PreStopDelay time.Duration `yaml:"pre_stop_delay" mapstructure:"pre_stop_delay"`
+	// Timeout is the budget for requests and streaming connections in flight, 10 seconds by default
+	Timeout time.Duration `yaml:"timeout" mapstructure:"timeout"`
+}
+
+// HealthConfig controls the dependency checks behind /readyz and /health. The checks run
+// in the background, so probes never call the upstreams themselves.
+type HealthConfig struct {
+	// Interval between checks, 10 seconds by default
+	Interval time.Duration `yaml:"interval" mapstructure:"interval"`
+	// Timeout of each check, 2 seconds by default
+	Timeout time.Duration `yaml:"timeout" mapstructure:"timeout"`
+	// Path is the health endpoint of the upstream services, /health by default
+	Path string `yaml:"path" mapstructure:"path"`
+	// Redis selects whether the gateway is ready without its cache: required (default) or optional
+	Redis string `yaml:"redis" mapstructure:"redis"`
+	// CriticalServices must be healthy for the gateway to be ready
+	CriticalServices []string `yaml:"critical_services" mapstructure:"critical_services"`
+}
+
+// MetricsConfig exposes Prometheus metrics on /metrics
+type MetricsConfig struct {
+	Enabled bool `yaml:"enabled" mapstructure:"enabled"`
+}
+
+// TracingConfig exports OpenTelemetry traces of the requests and their upstream calls
+type TracingConfig struct {
+	Enabled     bool   `yaml:"enabled" mapstructure:"enabled"`
+	ServiceName string `yaml:"service_name" mapstructure:"service_name"`
+	// Exporter selects where spans are sent: otlp (default) or stdout
+	Exporter string `yaml:"exporter" mapstructure:"exporter"`
+	// Endpoint is the host:port of the OTLP/HTTP collector, localhost:4318 by default
+	Endpoint string `yaml:"endpoint" mapstructure:"endpoint"`
+	Insecure bool   `yaml:"insecure" mapstructure:"insecure"`
+	// Headers are sent to the collector, e.g. an API key
+	Headers map[string]string `yaml:"headers" mapstructure:"headers" secret:"true"`
+	// SampleRatio is the share of new traces recorded, 1 when unset. Traces started
+	// by a caller follow its sampling decision.
+	SampleRatio *float64 `yaml:"sample_ratio" mapstructure:"sample_ratio"`
+	// Propagators are the trace context formats read and written: tracecontext,
+	// baggage, b3 (single header) or b3multi. Defaults to tracecontext and baggage.
+	Propagators []string `yaml:"propagators" mapstructure:"propagators"`
+}
+
+// LoggingConfig controls what the application and access logs may contain
+type LoggingConfig struct {
+	// Level is the minimum level logged: debug, info, warn or error. It defaults to debug
+	// in development and info elsewhere, and can be changed at runtime on /admin/log-level.
+	Level     string            `yaml:"level" mapstructure:"level"`
+	Sampling  LogSamplingConfig `yaml:"sampling" mapstructure:"sampling"`
+	Redaction RedactionConfig   `yaml:"redaction" mapstructure:"redaction"`
+}
+
+// LogSamplingConfig limits the request log lines written under load
+type LogSamplingConfig struct {
+	Enabled bool `yaml:"enabled" mapstructure:"enabled"`
+	// Rate is the share of successful requests logged, from 0 to 1. Failed requests,
+	// requests slower than SlowThreshold and debugged requests are always logged.
+	Rate          float64       `yaml:"rate" mapstructure:"rate"`
+	SlowThreshold time.Duration `yaml:"slow_threshold" mapstructure:"slow_threshold"`
+	// DedupeWindow drops repeats of an error or warning message within the window and
+	// reports how many were dropped on the next one. Request log lines are kept.
+	DedupeWindow time.Duration `yaml:"dedupe_window" mapstructure:"dedupe_window"`
+}
+
+// RedactionConfig masks sensitive data in every log entry. The settings add to the
+// built-in Authorization, Cookie and Set-Cookie headers and card number and email patterns.
+type RedactionConfig struct {
+	// Headers are masked wherever request or response headers are logged
+	Headers []string `yaml:"headers" mapstructure:"headers"`
+	// JSONFields are dot separated paths masked in logged JSON bodies, e.g.
+	// "user.ssn". Arrays are traversed and "*" matches any key.
+	JSONFields []string `yaml:"json_fields" mapstructure:"json_fields"`
+	// Patterns are regular expressions masked in every message and field
+	Patterns []string `yaml:"patterns" mapstructure:"patterns"`
+	// MaxBodySize truncates logged bodies to this many bytes, 1024 by default
+	MaxBodySize int `yaml:"max_body_size" mapstructure:"max_body_size"`
+}
+
+// CaptureConfig records the full requests and responses matching the capture rules
+// created on /admin/captures/rules, after redaction
+type CaptureConfig struct {
+	Enabled bool `yaml:"enabled" mapstructure:"enabled"`
+	// BufferSize is the number of captures kept in memory, 100 by default
+	BufferSize int `yaml:"buffer_size" mapstructure:"buffer_size"`
+	// MaxBodySize truncates captured bodies to this many bytes, 64 KiB by default
+	MaxBodySize int64 `yaml:"max_body_size" mapstructure:"max_body_size"`
+	// Retention drops captures older than this, 1 hour by default
+	Retention time.Duration `yaml:"retention" mapstructure:"retention"`
+	// File also appends the captures as JSON lines to a rotating file
+	File CaptureFileConfig `yaml:"file" mapstructure:"file"`
+}
+
+type CaptureFileConfig struct {
+	Path       string `yaml:"path" mapstructure:"path"`
+	MaxSize    int    `yaml:"max_size" mapstructure:"max_size"`
+	MaxBackups int    `yaml:"max_backups" mapstructure:"max_backups"`
+}
+
+// RecordingConfig appends the proxied requests and their responses, after redaction,
+// to a JSON lines file that `replay` sends again to a gateway or backend
+type RecordingConfig struct {
+	Enabled    bool   `yaml:"enabled" mapstructure:"enabled"`
+	Path       string `yaml:"path" mapstructure:"path"`
+	MaxSize    int    `yaml:"max_size" mapstructure:"max_size"`
+	MaxBackups int    `yaml:"max_backups" mapstructure:"max_backups"`
+	// SampleRate is the share of the requests recorded, all by default
+	SampleRate *float64 `yaml:"sample_rate" mapstructure:"sample_rate"`
+	// Services only records the traffic of these services, all when empty
+	Services []string `yaml:"services" mapstructure:"services"`
+	// MaxBodySize truncates recorded bodies to this many bytes, 1 MiB by default
+	MaxBodySize int64 `yaml:"max_body_size" mapstructure:"max_body_size"`
+}
+
+// AccessLogConfig writes one line per request, separate from the application log
+type AccessLogConfig struct {
+	Enabled bool `yaml:"enabled" mapstructure:"enabled"`
+	// Format selects the line format: json (default), common, combined or template
+	Format string `yaml:"format" mapstructure:"format"`
+	// Template is a text/template over the access log entry, e.g.
+	// "{{.RemoteAddr}} {{.Method}} {{.Path}} {{.Status}} {{.Duration}}"
+	Template string `yaml:"template" mapstructure:"template"`
+	// Sinks receive every line, stdout when empty
+	Sinks []AccessLogSinkConfig `yaml:"sinks" mapstructure:"sinks"`
+}
+
+// AccessLogSinkConfig is a destination of the access log
+type AccessLogSinkConfig struct {
+	// Type is stdout, file or syslog
+	Type string `yaml:"type" mapstructure:"type"`
+	// Path of the file sink, rotated once it reaches MaxSize megabytes. MaxBackups
+	// rotated files are kept.
+	Path       string `yaml:"path" mapstructure:"path"`
+	MaxSize    int    `yaml:"max_size" mapstructure:"max_size"`
+	MaxBackups int    `yaml:"max_backups" mapstructure:"max_backups"`
+	// Network and Address of the syslog server, e.g. udp and localhost:514. The local
+	// syslog socket is used when empty.
+	Network string `yaml:"network" mapstructure:"network"`
+	Address string `yaml:"address" mapstructure:"address"`
+	Tag     string `yaml:"tag" mapstructure:"tag"`
+}
+
+type PriorityConfig struct {
+	Enabled       bool                  `yaml:"enabled" mapstructure:"enabled"`
+	MaxConcurrent int                   `yaml:"max_concurrent" mapstructure:"max_concurrent"`
+	MaxQueue      int                   `yaml:"max_queue" mapstructure:"max_queue"`
+	QueueTimeout  time.Duration         `yaml:"queue_timeout" mapstructure:"queue_timeout"`
+	TierHeader    string                `yaml:"tier_header" mapstructure:"tier_header"`
+	DefaultClass  string                `yaml:"default_class" mapstructure:"default_class"`
+	Classes       []PriorityClassConfig `yaml:"classes" mapstructure:"classes"`
+}
+
+// PriorityClassConfig matches a request when every non-empty criteria matches
+type PriorityClassConfig struct {
+	Name          string            `yaml:"name" mapstructure:"name"`
+	Weight        int               `yaml:"weight" mapstructure:"weight"`
+	Routes        []string          `yaml:"routes" mapstructure:"routes"`
+	Headers       map[string]string `yaml:"headers" mapstructure:"headers"`
+	Tiers         []string          `yaml:"tiers" mapstructure:"tiers"`
+	Authenticated *bool             `yaml:"authenticated" mapstructure:"authenticated"`
 }
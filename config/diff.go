@@ -0,0 +1,204 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// REDACTED replaces the value of fields tagged secret:"true", or of every entry of
+// maps tagged so, in printed configuration
+const REDACTED = "[REDACTED]"
+
+// Flatten returns the configuration as dotted setting names, e.g. "ratelimit.limit",
+// mapped to their printed values. Secret values are redacted.
+func Flatten(cfg *Config) map[string]string {
+	values := make(map[string]string)
+	flatten(reflect.ValueOf(cfg).Elem(), "", false, values)
+	return values
+}
+
+// Redact returns a copy of the configuration with the values of fields tagged
+// secret:"true" replaced by REDACTED
+func Redact(cfg *Config) *Config {
+	redacted := *cfg
+	redact(reflect.ValueOf(&redacted).Elem(), false)
+	return &redacted
+}
+
+// Diff lists the settings that differ between two configurations, e.g.
+// "ratelimit.limit: 100 -> 200", sorted by setting name
+func Diff(old, new *Config) []string {
+	before, after := Flatten(old), Flatten(new)
+
+	var changes []string
+	for key, value := range after {
+		if previous, ok := before[key]; !ok {
+			changes = append(changes, fmt.Sprintf("%s: added %s", key, value))
+		} else if value == REDACTED && previous == REDACTED {
+			if secretChanged(old, new, key) {
+				changes = append(changes, fmt.Sprintf("%s: changed", key))
+			}
+		} else if previous != value {
+			changes = append(changes, fmt.Sprintf("%s: %s -> %s", key, previous, value))
+		}
+	}
+
+	for key := range before {
+		if _, ok := after[key]; !ok {
+			changes = append(changes, fmt.Sprintf("%s: removed", key))
+		}
+	}
+
+	sort.Strings(changes)
+	return changes
+}
+
+// Changed reports whether any setting under one of the prefixes, e.g. "cache", differs
+func Changed(changes []string, prefixes ...string) bool {
+	for _, change := range changes {
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(change, prefix+":") || strings.HasPrefix(change, prefix+".") || strings.HasPrefix(change, prefix+"[") {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func flatten(v reflect.Value, prefix string, secret bool, values map[string]string) {
+	switch v.Kind() {
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			name := field.Tag.Get("mapstructure")
+			if name == "" {
+				name = strings.ToLower(field.Name)
+			}
+			flatten(v.Field(i), join(prefix, name), field.Tag.Get("secret") == "true", values)
+		}
+	case reflect.Slice:
+		if v.Type().Elem().Kind() != reflect.Struct {
+			values[prefix] = printValue(v, secret)
+			return
+		}
+		for i := 0; i < v.Len(); i++ {
+			flatten(v.Index(i), fmt.Sprintf("%s[%d]", prefix, i), secret, values)
+		}
+	case reflect.Map:
+		for _, key := range v.MapKeys() {
+			flatten(v.MapIndex(key), join(prefix, fmt.Sprint(key.Interface())), secret, values)
+		}
+	case reflect.Ptr:
+		if v.IsNil() {
+			return
+		}
+		flatten(v.Elem(), prefix, secret, values)
+	default:
+		values[prefix] = printValue(v, secret)
+	}
+}
+
+// redact walks nested structs, slices and maps. The copy of the configuration shares
+// them with the original, so slices and secret maps are copied before being changed.
+func redact(v reflect.Value, secret bool) {
+	switch v.Kind() {
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			if t.Field(i).IsExported() {
+				redact(v.Field(i), secret || t.Field(i).Tag.Get("secret") == "true")
+			}
+		}
+	case reflect.Slice:
+		if v.Len() == 0 || v.Type().Elem().Kind() != reflect.Struct {
+			return
+		}
+		copied := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		reflect.Copy(copied, v)
+		v.Set(copied)
+		for i := 0; i < v.Len(); i++ {
+			redact(v.Index(i), secret)
+		}
+	case reflect.Map:
+		if !secret || v.Len() == 0 || v.Type().Elem().Kind() != reflect.String {
+			return
+		}
+		copied := reflect.MakeMapWithSize(v.Type(), v.Len())
+		for _, key := range v.MapKeys() {
+			value := v.MapIndex(key)
+			if value.String() != "" {
+				value = reflect.ValueOf(REDACTED).Convert(v.Type().Elem())
+			}
+			copied.SetMapIndex(key, value)
+		}
+		v.Set(copied)
+	case reflect.String:
+		if secret && v.String() != "" {
+			v.SetString(REDACTED)
+		}
+	}
+}
+
+func printValue(v reflect.Value, secret bool) string {
+	if secret {
+		if v.IsZero() {
+			return ""
+		}
+		return REDACTED
+	}
+
+	if d, ok := v.Interface().(time.Duration); ok {
+		return d.String()
+	}
+
+	return fmt.Sprint(v.Interface())
+}
+
+// secretChanged compares the raw values of a redacted setting
+func secretChanged(old, new *Config, key string) bool {
+	return lookup(reflect.ValueOf(old).Elem(), key) != lookup(reflect.ValueOf(new).Elem(), key)
+}
+
+func lookup(v reflect.Value, key string) string {
+	for _, name := range strings.Split(key, ".") {
+		// Entries of secret maps, e.g. "tracing.headers.authorization"
+		if v.Kind() == reflect.Map && v.Type().Key().Kind() == reflect.String {
+			v = v.MapIndex(reflect.ValueOf(name).Convert(v.Type().Key()))
+			if !v.IsValid() {
+				return ""
+			}
+			continue
+		}
+
+		if v.Kind() != reflect.Struct {
+			return ""
+		}
+
+		t := v.Type()
+		found := false
+		for i := 0; i < t.NumField(); i++ {
+			if t.Field(i).Tag.Get("mapstructure") == name {
+				v = v.Field(i)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return ""
+		}
+	}
+
+	return fmt.Sprint(v.Interface())
+}
+
+func join(prefix, name string) string {
+	if prefix == "" {
+		return name
+	}
+	return prefix + "." + name
+}
@@ -0,0 +1,50 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRedactSecretMaps(t *testing.T) {
+	cfg := &Config{
+		Auth:    AuthConfig{JWTSecret: "jwt-secret"},
+		Tracing: TracingConfig{Headers: map[string]string{"authorization": "Bearer key", "empty": ""}},
+		Server:  ServerConfig{Listeners: []ListenerConfig{{Name: "public", Port: "443"}}},
+	}
+
+	redacted := Redact(cfg)
+	if redacted.Auth.JWTSecret != REDACTED {
+		t.Errorf("got jwt_secret %q, want it redacted", redacted.Auth.JWTSecret)
+	}
+	want := map[string]string{"authorization": REDACTED, "empty": ""}
+	if !reflect.DeepEqual(redacted.Tracing.Headers, want) {
+		t.Errorf("got tracing.headers %v, want %v", redacted.Tracing.Headers, want)
+	}
+	if redacted.Server.Listeners[0].Port != "443" {
+		t.Errorf("got listener port %q, want it kept", redacted.Server.Listeners[0].Port)
+	}
+
+	// The original configuration is still used by the gateway
+	if cfg.Auth.JWTSecret != "jwt-secret" || cfg.Tracing.Headers["authorization"] != "Bearer key" {
+		t.Errorf("original configuration changed: %+v", cfg)
+	}
+}
+
+func TestFlattenAndDiffSecretMaps(t *testing.T) {
+	old := &Config{Tracing: TracingConfig{Headers: map[string]string{"authorization": "Bearer old"}}}
+	new := &Config{Tracing: TracingConfig{Headers: map[string]string{"authorization": "Bearer new"}}}
+
+	if value := Flatten(new)["tracing.headers.authorization"]; value != REDACTED {
+		t.Errorf("got tracing.headers.authorization %q, want it redacted", value)
+	}
+
+	changes := Diff(old, new)
+	want := []string{"tracing.headers.authorization: changed"}
+	if !reflect.DeepEqual(changes, want) {
+		t.Errorf("got changes %v, want %v", changes, want)
+	}
+
+	if changes := Diff(old, old); len(changes) != 0 {
+		t.Errorf("got changes %v for the same configuration, want none", changes)
+	}
+}
@@ -2,29 +2,64 @@ package config
 
 import (
 	"fmt"
+	"slices"
 	"strings"
 
+	"github.com/mitchellh/mapstructure"
 	"github.com/spf13/viper"
 )
 
+// LoadConfig merges config.yaml, the config.<env>.yaml overlay and the conf.d/*.yaml
+// fragments found in path, applies environment overrides, resolves ${env:NAME} and
+// ${file:/path} references in string values and validates the result. Unknown keys and
+// invalid settings are reported together as ValidationErrors.
 func LoadConfig(path string, config *Config) error {
-	v := viper.New()
+	_, err := loadConfig(path, config)
+	return err
+}
 
-	v.SetConfigName("config")
-	v.SetConfigType("yaml")
-	v.AddConfigPath(path)
+// loadConfig loads the configuration like LoadConfig, and returns where it came from
+func loadConfig(path string, config *Config) (*Sources, error) {
+	settings, sources, err := readSources(path)
+	if err != nil {
+		return nil, err
+	}
 
-	if err := v.ReadInConfig(); err != nil {
-		return fmt.Errorf("failed to read config file: %w", err)
+	v := viper.New()
+	if err := v.MergeConfigMap(settings); err != nil {
+		return nil, fmt.Errorf("failed to merge config: %w", err)
 	}
 
 	// Override config values with environment variables
 	v.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
 	v.AutomaticEnv()
 
-	if err := v.Unmarshal(&config); err != nil {
-		return fmt.Errorf("failed to unmarshal config: %w", err)
+	var metadata mapstructure.Metadata
+	if err := v.Unmarshal(&config, func(dc *mapstructure.DecoderConfig) {
+		dc.Metadata = &metadata
+	}); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal config: %w", err)
+	}
+
+	// Typos are otherwise silently ignored and leave the setting at its zero value
+	var errs ValidationErrors
+	slices.Sort(metadata.Unused)
+	for _, key := range metadata.Unused {
+		errs = append(errs, ValidationError{Path: key, Message: "unknown setting in " + sources.Source(key)})
+	}
+
+	sources.SecretFiles, err = resolveReferences(config)
+	if err != nil {
+		errs = append(errs, err.(ValidationErrors)...)
+	}
+
+	if err := Validate(config); err != nil {
+		errs = append(errs, err.(ValidationErrors)...)
+	}
+
+	if len(errs) > 0 {
+		return sources, errs
 	}
 
-	return nil
+	return sources, nil
 }
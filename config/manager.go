@@ -0,0 +1,184 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// RELOAD_DEBOUNCE groups the burst of file events produced by a single config update
+const RELOAD_DEBOUNCE = 250 * time.Millisecond
+
+// Manager holds the current configuration and swaps it atomically on reload.
+// Components read it with Get on every request instead of keeping a copy.
+type Manager struct {
+	path        string
+	current     atomic.Pointer[Config]
+	mu          sync.Mutex
+	subscribers []func(old, new *Config)
+	sources     *Sources
+}
+
+// NewManager loads and validates the configuration found in path
+func NewManager(path string) (*Manager, error) {
+	cfg, sources, err := load(path)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Manager{path: path, sources: sources}
+	m.current.Store(cfg)
+
+	return m, nil
+}
+
+// Get returns the current configuration, which must be treated as read-only
+func (m *Manager) Get() *Config {
+	return m.current.Load()
+}
+
+// Path returns the directory the configuration is loaded from
+func (m *Manager) Path() string {
+	return m.path
+}
+
+// Sources returns where the current configuration was read from
+func (m *Manager) Sources() *Sources {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.sources
+}
+
+// Subscribe registers a function called after every successful reload
+func (m *Manager) Subscribe(fn func(old, new *Config)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.subscribers = append(m.subscribers, fn)
+}
+
+// Reload parses and validates the configuration again and swaps it in. On failure the
+// current configuration is kept. It returns the list of changed settings.
+func (m *Manager) Reload() ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	cfg, sources, err := load(m.path)
+	if err != nil {
+		return nil, err
+	}
+
+	m.sources = sources
+	old := m.current.Load()
+	changes := Diff(old, cfg)
+	if len(changes) == 0 {
+		return nil, nil
+	}
+
+	m.current.Store(cfg)
+	for _, fn := range m.subscribers {
+		fn(old, cfg)
+	}
+
+	return changes, nil
+}
+
+// Watch reloads the configuration when a file in the config directory, its conf.d
+// fragments or a referenced secret file changes, or the process receives SIGHUP, until
+// ctx is done. onReload is called with the outcome.
+func (m *Manager) Watch(ctx context.Context, onReload func(changes []string, err error)) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to create config watcher: %w", err)
+	}
+
+	// Watch the directory rather than the file, editors and Kubernetes replace files
+	if err := watcher.Add(m.path); err != nil {
+		watcher.Close()
+		return fmt.Errorf("failed to watch config directory: %w", err)
+	}
+
+	// Fragments are optional, the directory may not exist
+	watcher.Add(filepath.Join(m.path, FRAGMENTS_DIR))
+	m.watchSecretFiles(watcher)
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	go func() {
+		defer watcher.Close()
+		defer signal.Stop(hup)
+
+		var debounce <-chan time.Time
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hup:
+				onReload(m.Reload())
+				m.watchSecretFiles(watcher)
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if filepath.Ext(event.Name) == ".yaml" || filepath.Base(event.Name) == "..data" || m.isSecretFile(event.Name) {
+					debounce = time.After(RELOAD_DEBOUNCE)
+				}
+			case <-debounce:
+				onReload(m.Reload())
+				m.watchSecretFiles(watcher)
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				onReload(nil, fmt.Errorf("config watcher error: %w", err))
+			}
+		}
+	}()
+
+	return nil
+}
+
+// watchSecretFiles watches the directories of the referenced secret files, Kubernetes
+// rotates mounted secrets by swapping a symlink in the directory
+func (m *Manager) watchSecretFiles(watcher *fsnotify.Watcher) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, file := range m.sources.SecretFiles {
+		// Adding a directory that is already watched is a no-op
+		watcher.Add(filepath.Dir(file))
+	}
+}
+
+func (m *Manager) isSecretFile(name string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, file := range m.sources.SecretFiles {
+		if filepath.Clean(file) == filepath.Clean(name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func load(path string) (*Config, *Sources, error) {
+	cfg := &Config{}
+	sources, err := loadConfig(path, cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return cfg, sources, nil
+}
@@ -0,0 +1,93 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+// referencePattern matches secret references such as ${env:REDIS_PASSWORD} or
+// ${file:/run/secrets/jwt} inside string values
+var referencePattern = regexp.MustCompile(`\$\{(env|file):([^}]+)\}`)
+
+// resolver replaces references in every string setting and remembers the files read,
+// so they can be watched for rotation
+type resolver struct {
+	files []string
+	errs  ValidationErrors
+}
+
+// resolveReferences resolves the references of cfg in place. It returns the files
+// referenced, and ValidationErrors for references that cannot be resolved.
+func resolveReferences(cfg *Config) ([]string, error) {
+	r := &resolver{}
+	r.walk(reflect.ValueOf(cfg).Elem(), "")
+
+	if len(r.errs) > 0 {
+		return r.files, r.errs
+	}
+
+	return r.files, nil
+}
+
+func (r *resolver) walk(v reflect.Value, path string) {
+	switch v.Kind() {
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			r.walk(v.Field(i), join(path, t.Field(i).Tag.Get("mapstructure")))
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			r.walk(v.Index(i), fmt.Sprintf("%s[%d]", path, i))
+		}
+	case reflect.Map:
+		if v.Type().Elem().Kind() != reflect.String {
+			return
+		}
+		for _, key := range v.MapKeys() {
+			value := v.MapIndex(key).String()
+			if resolved, ok := r.resolve(join(path, key.String()), value); ok {
+				v.SetMapIndex(key, reflect.ValueOf(resolved).Convert(v.Type().Elem()))
+			}
+		}
+	case reflect.String:
+		if resolved, ok := r.resolve(path, v.String()); ok {
+			v.SetString(resolved)
+		}
+	}
+}
+
+// resolve returns value with its references replaced, and whether it had any
+func (r *resolver) resolve(path, value string) (string, bool) {
+	if !strings.Contains(value, "${") {
+		return value, false
+	}
+
+	resolved := referencePattern.ReplaceAllStringFunc(value, func(ref string) string {
+		match := referencePattern.FindStringSubmatch(ref)
+		source, name := match[1], strings.TrimSpace(match[2])
+
+		switch source {
+		case "env":
+			secret, ok := os.LookupEnv(name)
+			if !ok {
+				r.errs = append(r.errs, ValidationError{Path: path, Message: fmt.Sprintf("environment variable %s is not set", name)})
+			}
+			return secret
+		default:
+			r.files = append(r.files, name)
+			content, err := os.ReadFile(name)
+			if err != nil {
+				r.errs = append(r.errs, ValidationError{Path: path, Message: fmt.Sprintf("cannot read secret file: %v", err)})
+				return ""
+			}
+			// Secret files usually end with a newline that is not part of the value
+			return strings.TrimRight(string(content), "\r\n")
+		}
+	})
+
+	return resolved, true
+}
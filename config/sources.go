@@ -0,0 +1,179 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// CONFIG_FILE is the base configuration file
+	CONFIG_FILE = "config.yaml"
+	// FRAGMENTS_DIR holds configuration fragments, e.g. the routes owned by one team
+	FRAGMENTS_DIR = "conf.d"
+	// SOURCE_DEFAULT is the provenance of settings not set by any source
+	SOURCE_DEFAULT = "default"
+)
+
+// Sources describes where the effective configuration was read from. Later sources
+// override earlier ones: the base file, the environment overlay config.<env>.yaml,
+// the conf.d/*.yaml fragments in name order, then environment variables.
+type Sources struct {
+	// Files are the configuration files merged, in order
+	Files []string
+	// Provenance maps each setting, e.g. "ratelimit.limit", to the file or
+	// environment variable it came from
+	Provenance map[string]string
+	// SecretFiles are the files referenced by ${file:...} values
+	SecretFiles []string
+}
+
+// Source returns where the setting came from
+func (s *Sources) Source(key string) string {
+	if source, ok := s.Provenance[key]; ok {
+		return source
+	}
+
+	return SOURCE_DEFAULT
+}
+
+// readSources merges the configuration files found in path into a single settings map
+func readSources(path string) (map[string]interface{}, *Sources, error) {
+	sources := &Sources{Provenance: make(map[string]string)}
+	settings := make(map[string]interface{})
+
+	base := filepath.Join(path, CONFIG_FILE)
+	if err := mergeFile(settings, base, path, false, sources); err != nil {
+		return nil, nil, err
+	}
+
+	// The overlay is chosen by env, which may itself be overridden by the ENV variable
+	env, _ := settings["env"].(string)
+	if value, ok := os.LookupEnv("ENV"); ok {
+		env = value
+	}
+
+	if env != "" {
+		overlay := filepath.Join(path, fmt.Sprintf("config.%s.yaml", env))
+		if _, err := os.Stat(overlay); err == nil {
+			if err := mergeFile(settings, overlay, path, false, sources); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+
+	// Lists in fragments are appended, so several teams can each add their own routes
+	fragments, err := filepath.Glob(filepath.Join(path, FRAGMENTS_DIR, "*.yaml"))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list config fragments: %w", err)
+	}
+	sort.Strings(fragments)
+
+	for _, fragment := range fragments {
+		if err := mergeFile(settings, fragment, path, true, sources); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// Viper applies environment variables to the settings present in the files
+	for key := range sources.Provenance {
+		if strings.Contains(key, "[") {
+			continue
+		}
+
+		name := strings.ToUpper(strings.ReplaceAll(key, ".", "_"))
+		if _, ok := os.LookupEnv(name); ok {
+			sources.Provenance[key] = "env:" + name
+		}
+	}
+
+	return settings, sources, nil
+}
+
+func mergeFile(settings map[string]interface{}, file, root string, appendLists bool, sources *Sources) error {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	var values map[string]interface{}
+	if err := yaml.Unmarshal(content, &values); err != nil {
+		return fmt.Errorf("failed to parse config file %s: %w", file, err)
+	}
+
+	name, err := filepath.Rel(root, file)
+	if err != nil {
+		name = file
+	}
+
+	sources.Files = append(sources.Files, file)
+	merge(settings, values, "", name, appendLists, sources.Provenance)
+
+	return nil
+}
+
+// merge copies src into dst, merging nested maps. Lists replace the existing list, or
+// are appended to it when appendLists is set. Keys are lowercased like viper does.
+func merge(dst, src map[string]interface{}, prefix, source string, appendLists bool, provenance map[string]string) {
+	for key, value := range src {
+		key = strings.ToLower(key)
+		path := join(prefix, key)
+
+		srcMap, srcIsMap := value.(map[string]interface{})
+		dstMap, dstIsMap := dst[key].(map[string]interface{})
+		if srcIsMap && dstIsMap {
+			merge(dstMap, srcMap, path, source, appendLists, provenance)
+			continue
+		}
+
+		srcList, srcIsList := value.([]interface{})
+		dstList, dstIsList := dst[key].([]interface{})
+		if srcIsList && dstIsList && appendLists {
+			for i, item := range srcList {
+				record(item, fmt.Sprintf("%s[%d]", path, len(dstList)+i), source, provenance)
+			}
+			dst[key] = append(dstList, srcList...)
+			continue
+		}
+
+		for existing := range provenance {
+			if existing == path || strings.HasPrefix(existing, path+".") || strings.HasPrefix(existing, path+"[") {
+				delete(provenance, existing)
+			}
+		}
+
+		if srcIsMap {
+			// Copy so later merges don't modify the source of an earlier value
+			copied := make(map[string]interface{})
+			merge(copied, srcMap, path, source, false, provenance)
+			dst[key] = copied
+			continue
+		}
+
+		dst[key] = value
+		record(value, path, source, provenance)
+	}
+}
+
+// record sets the provenance of every setting in value
+func record(value interface{}, path, source string, provenance map[string]string) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, item := range v {
+			record(item, join(path, strings.ToLower(key)), source, provenance)
+		}
+	case []interface{}:
+		if len(v) == 0 {
+			provenance[path] = source
+		}
+		for i, item := range v {
+			record(item, fmt.Sprintf("%s[%d]", path, i), source, provenance)
+		}
+	default:
+		provenance[path] = source
+	}
+}
@@ -0,0 +1,496 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+var (
+	cacheDrivers       = []string{"", "redis", "memory"}
+	cacheModes         = []string{"", "standalone", "sentinel", "cluster"}
+	ratelimitHeaders   = []string{"", "legacy", "ietf", "both"}
+	idempotencyModes   = []string{"", "disabled", "required", "optional", "auto"}
+	concurrentPolicies = []string{"", "reject", "wait"}
+	tracingExporters   = []string{"", "otlp", "stdout"}
+	tracingPropagators = []string{"", "tracecontext", "baggage", "b3", "b3multi"}
+	accessLogFormats   = []string{"", "json", "common", "combined", "template"}
+	accessLogSinks     = []string{"", "stdout", "file", "syslog"}
+	syslogNetworks     = []string{"", "udp", "tcp", "unix", "unixgram"}
+	logLevels          = []string{"", "debug", "info", "warn", "error"}
+	healthRedisPolicy  = []string{"", "required", "optional"}
+	tlsVersions        = []string{"", "1.2", "1.3"}
+	listenerRoutes     = []string{"", ROUTES_PROBES, ROUTES_HEALTH, ROUTES_METRICS, ROUTES_PRIORITY, ROUTES_CACHE, ROUTES_ADMIN, ROUTES_PROXY}
+	// gatewayPaths are the first path segments of the gateway's own routes, which shadow
+	// any service of the same name
+	gatewayPaths = []string{"livez", "readyz", ROUTES_HEALTH, ROUTES_METRICS, ROUTES_PRIORITY, ROUTES_CACHE, ROUTES_ADMIN}
+)
+
+// ValidationError describes an invalid setting by its YAML path, e.g. "server.tls.cert_file"
+type ValidationError struct {
+	Path    string
+	Message string
+}
+
+func (e ValidationError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Path, e.Message)
+}
+
+// ValidationErrors reports every invalid setting at once, so they can be fixed in one go
+type ValidationErrors []ValidationError
+
+func (errs ValidationErrors) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "invalid configuration (%d errors):", len(errs))
+	for _, err := range errs {
+		b.WriteString("\n  - ")
+		b.WriteString(err.Error())
+	}
+
+	return b.String()
+}
+
+// validator collects validation errors
+type validator struct {
+	errs ValidationErrors
+}
+
+func (v *validator) add(path, format string, args ...interface{}) {
+	v.errs = append(v.errs, ValidationError{Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+func (v *validator) required(path, value string) {
+	if value == "" {
+		v.add(path, "is required")
+	}
+}
+
+func (v *validator) oneOf(path, value string, allowed []string) {
+	if !slices.Contains(allowed, value) {
+		v.add(path, "%q is not one of %s", value, strings.Join(allowed[1:], ", "))
+	}
+}
+
+func (v *validator) nonNegative(path string, d time.Duration) {
+	if d < 0 {
+		v.add(path, "must not be negative, got %s", d)
+	}
+}
+
+func (v *validator) routePath(path, value string) {
+	if !strings.HasPrefix(value, "/") {
+		v.add(path, "must be a path starting with /, got %q", value)
+	}
+}
+
+func (v *validator) port(path, value string) {
+	if port, err := strconv.Atoi(value); err != nil || port < 1 || port > 65535 {
+		v.add(path, "must be a port between 1 and 65535, got %q", value)
+	}
+}
+
+func (v *validator) file(path, value string) {
+	if value == "" {
+		v.add(path, "is required")
+		return
+	}
+
+	if _, err := os.Stat(value); err != nil {
+		v.add(path, "cannot read %q: %v", value, err)
+	}
+}
+
+// Validate checks the configuration and returns ValidationErrors listing every invalid
+// setting, so a broken file is rejected before it replaces the current configuration
+func Validate(cfg *Config) error {
+	v := &validator{}
+
+	validateServer(v, &cfg.Server)
+	validateCache(v, &cfg.Cache)
+	validateAuth(v, &cfg.Auth)
+	validateRatelimit(v, &cfg.Ratelimit)
+	validatePriority(v, &cfg.Priority)
+	validateIdempotency(v, &cfg.Idempotency)
+	validateResponseCache(v, &cfg.ResponseCache)
+	validateCoalescing(v, &cfg.Coalescing)
+	validateTracing(v, &cfg.Tracing)
+	validateAccessLog(v, &cfg.AccessLog)
+	validateLogging(v, &cfg.Logging)
+	validateCapture(v, &cfg.Capture)
+	validateRecording(v, &cfg.Recording, cfg.FowardServiceUrl)
+	validateHealth(v, &cfg.Health, cfg.FowardServiceUrl)
+	validateShutdown(v, &cfg.Shutdown)
+	validateServices(v, cfg.FowardServiceUrl)
+
+	if len(v.errs) > 0 {
+		return v.errs
+	}
+
+	return nil
+}
+
+func validateServer(v *validator, cfg *ServerConfig) {
+	if len(cfg.Listeners) == 0 {
+		v.port("server.port", cfg.Port)
+		validateTLS(v, "server.tls", &cfg.TLS)
+		return
+	}
+
+	names := make(map[string]*ListenerConfig, len(cfg.Listeners))
+	addrs := make(map[string]bool, len(cfg.Listeners))
+	for i := range cfg.Listeners {
+		listener := &cfg.Listeners[i]
+		path := fmt.Sprintf("server.listeners[%d]", i)
+
+		v.required(path+".name", listener.Name)
+		if names[listener.Name] != nil {
+			v.add(path+".name", "duplicate listener %q", listener.Name)
+		}
+		names[listener.Name] = listener
+
+		v.port(path+".port", listener.Port)
+		addr := listener.Host + ":" + listener.Port
+		if addrs[addr] {
+			v.add(path, "duplicate address %q", addr)
+		}
+		addrs[addr] = true
+
+		validateTLS(v, path+".tls", &listener.TLS)
+		v.nonNegative(path+".read_timeout", listener.ReadTimeout)
+		v.nonNegative(path+".read_header_timeout", listener.ReadHeaderTimeout)
+		v.nonNegative(path+".write_timeout", listener.WriteTimeout)
+		v.nonNegative(path+".idle_timeout", listener.IdleTimeout)
+
+		for j, route := range listener.Routes {
+			v.oneOf(fmt.Sprintf("%s.routes[%d]", path, j), route, listenerRoutes)
+		}
+	}
+
+	for i, listener := range cfg.Listeners {
+		if listener.Redirect == "" {
+			continue
+		}
+
+		path := fmt.Sprintf("server.listeners[%d]", i)
+		if target := names[listener.Redirect]; target == nil || !target.TLS.Enable {
+			v.add(path+".redirect", "must name a listener with TLS enabled, got %q", listener.Redirect)
+		}
+		if len(listener.Routes) > 0 {
+			v.add(path+".routes", "must be empty on a redirect listener")
+		}
+	}
+}
+
+func validateTLS(v *validator, path string, cfg *TLSConfig) {
+	if cfg.Enable {
+		v.file(path+".cert_file", cfg.CertFile)
+		v.file(path+".key_file", cfg.KeyFile)
+	}
+	v.oneOf(path+".min_version", cfg.MinVersion, tlsVersions)
+}
+
+func validateCache(v *validator, cfg *CacheConfig) {
+	v.oneOf("cache.driver", cfg.Driver, cacheDrivers)
+	if cfg.Driver == "memory" {
+		return
+	}
+
+	v.oneOf("cache.mode", cfg.Mode, cacheModes)
+	switch cfg.Mode {
+	case "sentinel":
+		v.required("cache.master_name", cfg.MasterName)
+		if len(cfg.Addrs) == 0 {
+			v.add("cache.addrs", "is required in sentinel mode")
+		}
+	case "cluster":
+		if len(cfg.Addrs) == 0 && cfg.Host == "" {
+			v.add("cache.addrs", "is required in cluster mode")
+		}
+	default:
+		if len(cfg.Addrs) == 0 {
+			v.required("cache.host", cfg.Host)
+			v.required("cache.port", cfg.Port)
+		}
+	}
+
+	if cfg.DB < 0 {
+		v.add("cache.db", "must not be negative, got %d", cfg.DB)
+	}
+	if cfg.PoolSize < 0 {
+		v.add("cache.pool_size", "must not be negative, got %d", cfg.PoolSize)
+	}
+	if cfg.MinIdleConns < 0 {
+		v.add("cache.min_idle_conns", "must not be negative, got %d", cfg.MinIdleConns)
+	}
+
+	v.nonNegative("cache.pool_timeout", cfg.PoolTimeout)
+	v.nonNegative("cache.dial_timeout", cfg.DialTimeout)
+	v.nonNegative("cache.read_timeout", cfg.ReadTimeout)
+	v.nonNegative("cache.write_timeout", cfg.WriteTimeout)
+
+	if cfg.TLS.Enable {
+		if cfg.TLS.CAFile != "" {
+			v.file("cache.tls.ca_file", cfg.TLS.CAFile)
+		}
+		if cfg.TLS.CertFile != "" || cfg.TLS.KeyFile != "" {
+			v.file("cache.tls.cert_file", cfg.TLS.CertFile)
+			v.file("cache.tls.key_file", cfg.TLS.KeyFile)
+		}
+	}
+}
+
+func validateAuth(v *validator, cfg *AuthConfig) {
+	// An empty secret would validate any token signed with an empty HMAC key
+	v.required("auth.jwt_secret", cfg.JWTSecret)
+	v.nonNegative("auth.jwt_expiration", cfg.JWTExpiration)
+}
+
+func validateRatelimit(v *validator, cfg *RatelimitConfig) {
+	if cfg.Limit <= 0 {
+		v.add("ratelimit.limit", "must be positive, got %d", cfg.Limit)
+	}
+	if cfg.Period <= 0 {
+		v.add("ratelimit.period", "must be a positive duration, got %s", cfg.Period)
+	}
+
+	v.oneOf("ratelimit.headers", cfg.Headers, ratelimitHeaders)
+}
+
+func validatePriority(v *validator, cfg *PriorityConfig) {
+	if !cfg.Enabled {
+		return
+	}
+
+	if cfg.MaxConcurrent <= 0 {
+		v.add("priority.max_concurrent", "must be positive when priority is enabled, got %d", cfg.MaxConcurrent)
+	}
+	if cfg.MaxQueue < 0 {
+		v.add("priority.max_queue", "must not be negative, got %d", cfg.MaxQueue)
+	}
+
+	v.nonNegative("priority.queue_timeout", cfg.QueueTimeout)
+
+	names := make(map[string]bool)
+	for i, class := range cfg.Classes {
+		path := fmt.Sprintf("priority.classes[%d]", i)
+		v.required(path+".name", class.Name)
+		if names[class.Name] {
+			v.add(path+".name", "duplicate class %q", class.Name)
+		}
+		names[class.Name] = true
+
+		if class.Weight <= 0 {
+			v.add(path+".weight", "must be positive, got %d", class.Weight)
+		}
+	}
+}
+
+func validateIdempotency(v *validator, cfg *IdempotencyConfig) {
+	v.oneOf("idempotency.mode", cfg.Mode, idempotencyModes)
+	v.oneOf("idempotency.concurrent_policy", cfg.ConcurrentPolicy, concurrentPolicies)
+	v.nonNegative("idempotency.lock_ttl", cfg.LockTTL)
+	v.nonNegative("idempotency.wait_timeout", cfg.WaitTimeout)
+	v.nonNegative("idempotency.ttl", cfg.TTL)
+
+	if cfg.MaxBodySize < 0 {
+		v.add("idempotency.max_body_size", "must not be negative, got %d", cfg.MaxBodySize)
+	}
+
+	for i, route := range cfg.Routes {
+		path := fmt.Sprintf("idempotency.routes[%d]", i)
+		v.routePath(path+".path", route.Path)
+		v.oneOf(path+".mode", route.Mode, idempotencyModes)
+		v.nonNegative(path+".ttl", route.TTL)
+		v.nonNegative(path+".window", route.Window)
+	}
+}
+
+func validateResponseCache(v *validator, cfg *ResponseCacheConfig) {
+	if cfg.MaxBodySize < 0 {
+		v.add("response_cache.max_body_size", "must not be negative, got %d", cfg.MaxBodySize)
+	}
+
+	v.nonNegative("response_cache.stale_while_revalidate", cfg.StaleWhileRevalidate)
+	v.nonNegative("response_cache.stale_if_error", cfg.StaleIfError)
+
+	for i, route := range cfg.Routes {
+		path := fmt.Sprintf("response_cache.routes[%d]", i)
+		v.routePath(path+".path", route.Path)
+		v.nonNegative(path+".ttl", route.TTL)
+		v.nonNegative(path+".stale_while_revalidate", route.StaleWhileRevalidate)
+		v.nonNegative(path+".stale_if_error", route.StaleIfError)
+	}
+}
+
+func validateCoalescing(v *validator, cfg *CoalescingConfig) {
+	v.nonNegative("coalescing.max_wait", cfg.MaxWait)
+	if cfg.MaxBodySize < 0 {
+		v.add("coalescing.max_body_size", "must not be negative, got %d", cfg.MaxBodySize)
+	}
+
+	for i, route := range cfg.Routes {
+		path := fmt.Sprintf("coalescing.routes[%d]", i)
+		v.routePath(path+".path", route.Path)
+		v.nonNegative(path+".max_wait", route.MaxWait)
+	}
+}
+
+func validateTracing(v *validator, cfg *TracingConfig) {
+	v.oneOf("tracing.exporter", cfg.Exporter, tracingExporters)
+
+	if cfg.SampleRatio != nil && (*cfg.SampleRatio < 0 || *cfg.SampleRatio > 1) {
+		v.add("tracing.sample_ratio", "must be between 0 and 1, got %g", *cfg.SampleRatio)
+	}
+
+	for i, propagator := range cfg.Propagators {
+		v.oneOf(fmt.Sprintf("tracing.propagators[%d]", i), propagator, tracingPropagators)
+	}
+}
+
+func validateAccessLog(v *validator, cfg *AccessLogConfig) {
+	v.oneOf("access_log.format", cfg.Format, accessLogFormats)
+	if cfg.Format == "template" {
+		v.required("access_log.template", cfg.Template)
+		if _, err := template.New("access_log").Parse(cfg.Template); err != nil {
+			v.add("access_log.template", "invalid template: %v", err)
+		}
+	}
+
+	for i, sink := range cfg.Sinks {
+		path := fmt.Sprintf("access_log.sinks[%d]", i)
+		v.oneOf(path+".type", sink.Type, accessLogSinks)
+		switch sink.Type {
+		case "file":
+			v.required(path+".path", sink.Path)
+			if sink.MaxSize < 0 {
+				v.add(path+".max_size", "must not be negative, got %d", sink.MaxSize)
+			}
+			if sink.MaxBackups < 0 {
+				v.add(path+".max_backups", "must not be negative, got %d", sink.MaxBackups)
+			}
+		case "syslog":
+			v.oneOf(path+".network", sink.Network, syslogNetworks)
+			if sink.Network != "" {
+				v.required(path+".address", sink.Address)
+			}
+		}
+	}
+}
+
+func validateLogging(v *validator, cfg *LoggingConfig) {
+	v.oneOf("logging.level", cfg.Level, logLevels)
+
+	if cfg.Sampling.Rate < 0 || cfg.Sampling.Rate > 1 {
+		v.add("logging.sampling.rate", "must be between 0 and 1, got %g", cfg.Sampling.Rate)
+	}
+	v.nonNegative("logging.sampling.slow_threshold", cfg.Sampling.SlowThreshold)
+	v.nonNegative("logging.sampling.dedupe_window", cfg.Sampling.DedupeWindow)
+
+	for i, field := range cfg.Redaction.JSONFields {
+		path := fmt.Sprintf("logging.redaction.json_fields[%d]", i)
+		if field == "" || slices.Contains(strings.Split(field, "."), "") {
+			v.add(path, "must be a dot separated path, got %q", field)
+		}
+	}
+
+	for i, pattern := range cfg.Redaction.Patterns {
+		if _, err := regexp.Compile(pattern); err != nil {
+			v.add(fmt.Sprintf("logging.redaction.patterns[%d]", i), "invalid regular expression: %v", err)
+		}
+	}
+
+	if cfg.Redaction.MaxBodySize < 0 {
+		v.add("logging.redaction.max_body_size", "must not be negative, got %d", cfg.Redaction.MaxBodySize)
+	}
+}
+
+func validateCapture(v *validator, cfg *CaptureConfig) {
+	if cfg.BufferSize < 0 {
+		v.add("capture.buffer_size", "must not be negative, got %d", cfg.BufferSize)
+	}
+	if cfg.MaxBodySize < 0 {
+		v.add("capture.max_body_size", "must not be negative, got %d", cfg.MaxBodySize)
+	}
+	v.nonNegative("capture.retention", cfg.Retention)
+
+	if cfg.File.MaxSize < 0 {
+		v.add("capture.file.max_size", "must not be negative, got %d", cfg.File.MaxSize)
+	}
+	if cfg.File.MaxBackups < 0 {
+		v.add("capture.file.max_backups", "must not be negative, got %d", cfg.File.MaxBackups)
+	}
+}
+
+func validateRecording(v *validator, cfg *RecordingConfig, services map[string]string) {
+	if cfg.Enabled {
+		v.required("recording.path", cfg.Path)
+	}
+
+	if cfg.SampleRate != nil && (*cfg.SampleRate < 0 || *cfg.SampleRate > 1) {
+		v.add("recording.sample_rate", "must be between 0 and 1, got %g", *cfg.SampleRate)
+	}
+	if cfg.MaxSize < 0 {
+		v.add("recording.max_size", "must not be negative, got %d", cfg.MaxSize)
+	}
+	if cfg.MaxBackups < 0 {
+		v.add("recording.max_backups", "must not be negative, got %d", cfg.MaxBackups)
+	}
+	if cfg.MaxBodySize < 0 {
+		v.add("recording.max_body_size", "must not be negative, got %d", cfg.MaxBodySize)
+	}
+
+	for i, service := range cfg.Services {
+		if _, ok := services[service]; !ok {
+			v.add(fmt.Sprintf("recording.services[%d]", i), "unknown service %q", service)
+		}
+	}
+}
+
+func validateHealth(v *validator, cfg *HealthConfig, services map[string]string) {
+	v.nonNegative("health.interval", cfg.Interval)
+	v.nonNegative("health.timeout", cfg.Timeout)
+	if cfg.Path != "" {
+		v.routePath("health.path", cfg.Path)
+	}
+	v.oneOf("health.redis", cfg.Redis, healthRedisPolicy)
+
+	for i, service := range cfg.CriticalServices {
+		if _, ok := services[service]; !ok {
+			v.add(fmt.Sprintf("health.critical_services[%d]", i), "unknown service %q", service)
+		}
+	}
+}
+
+func validateShutdown(v *validator, cfg *ShutdownConfig) {
+	v.nonNegative("shutdown.pre_stop_delay", cfg.PreStopDelay)
+	v.nonNegative("shutdown.timeout", cfg.Timeout)
+}
+
+func validateServices(v *validator, services map[string]string) {
+	names := make([]string, 0, len(services))
+	for name := range services {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	for _, name := range names {
+		path := "forward_service_url." + name
+		if strings.Contains(name, "/") {
+			v.add(path, "service name must be a single path segment")
+		}
+		if slices.Contains(gatewayPaths, name) {
+			v.add(path, "service name %q is taken by the gateway's /%s routes", name, name)
+		}
+
+		u, err := url.Parse(services[name])
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			v.add(path, "must be an absolute http or https URL, got %q", services[name])
+		}
+	}
+}
@@ -0,0 +1,17 @@
+package config
+
+import "testing"
+
+func TestValidateRejectsServicesShadowedByGatewayRoutes(t *testing.T) {
+	v := &validator{}
+	validateServices(v, map[string]string{
+		"metrics": "http://metrics:8080",
+		"readyz":  "http://probes:8080",
+		"user":    "http://user:8080",
+	})
+
+	errs := v.errs
+	if len(errs) != 2 || errs[0].Path != "forward_service_url.metrics" || errs[1].Path != "forward_service_url.readyz" {
+		t.Fatalf("got %v, want metrics and readyz rejected", errs)
+	}
+}
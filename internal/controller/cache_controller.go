@@ -0,0 +1,59 @@
+package controller
+
+import (
+	"api-gateway-service-ms/internal/middleware"
+	"api-gateway-service-ms/internal/pkg/logger"
+	"api-gateway-service-ms/internal/pkg/response"
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CacheController handles purging of the gateway response cache
+type CacheController struct {
+	responseCache *middleware.ResponseCacheMiddleware
+	logger        *logger.Logger
+}
+
+func NewCacheController(responseCache *middleware.ResponseCacheMiddleware, logger *logger.Logger) *CacheController {
+	return &CacheController{
+		responseCache: responseCache,
+		logger:        logger,
+	}
+}
+
+// Purge removes cached responses by cache key, path or tag given as query parameters
+func (cc *CacheController) Purge(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var (
+		purged int
+		err    error
+	)
+
+	switch {
+	case c.Query("key") != "":
+		purged, err = cc.responseCache.PurgeKey(ctx, c.Query("key"))
+	case c.Query("path") != "":
+		purged, err = cc.responseCache.PurgePath(ctx, c.Query("path"))
+	case c.Query("tag") != "":
+		purged, err = cc.responseCache.PurgeTag(ctx, c.Query("tag"))
+	default:
+		response.Error(c, http.StatusBadRequest, "One of key, path or tag is required")
+		return
+	}
+
+	if err != nil {
+		cc.logger.Errorf("Failed to purge response cache: %v", err)
+		response.Error(c, http.StatusInternalServerError, "Failed to purge response cache")
+		return
+	}
+
+	cc.logger.Infof("Purged %d response cache entries", purged)
+	response.Success(c, map[string]interface{}{
+		"purged": purged,
+	})
+}
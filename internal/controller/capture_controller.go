@@ -0,0 +1,101 @@
+package controller
+
+import (
+	"api-gateway-service-ms/internal/middleware"
+	"api-gateway-service-ms/internal/pkg/capture"
+	"api-gateway-service-ms/internal/pkg/logger"
+	"api-gateway-service-ms/internal/pkg/response"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CaptureController manages the capture rules and returns the captured traffic
+type CaptureController struct {
+	capture *middleware.CaptureMiddleware
+	logger  *logger.Logger
+}
+
+func NewCaptureController(capture *middleware.CaptureMiddleware, logger *logger.Logger) *CaptureController {
+	return &CaptureController{
+		capture: capture,
+		logger:  logger,
+	}
+}
+
+type addCaptureRuleRequest struct {
+	Route  string `json:"route"`
+	UserID string `json:"user_id"`
+	Header string `json:"header"`
+	Value  string `json:"value"`
+	// TTL is a duration such as "10m", 15 minutes by default
+	TTL string `json:"ttl"`
+}
+
+// GetRules returns the active capture rules
+func (cc *CaptureController) GetRules(c *gin.Context) {
+	response.Success(c, cc.capture.Recorder().Rules())
+}
+
+// AddRule starts capturing the matching requests until the rule expires
+func (cc *CaptureController) AddRule(c *gin.Context) {
+	var req addCaptureRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.Error(c, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	// Capturing every request would record far more than the traffic being debugged,
+	// and a route of "/" matches every request
+	if strings.Trim(req.Route, "/") == "" && req.UserID == "" && req.Header == "" {
+		response.Error(c, http.StatusBadRequest, "One of route below /, user_id or header is required")
+		return
+	}
+
+	ttl := DEFAULT_DEBUG_TTL
+	if req.TTL != "" {
+		var err error
+		ttl, err = time.ParseDuration(req.TTL)
+		if err != nil || ttl <= 0 || ttl > MAX_DEBUG_TTL {
+			response.Error(c, http.StatusBadRequest, "ttl must be a positive duration of at most 24h")
+			return
+		}
+	}
+
+	rule := cc.capture.Recorder().AddRule(capture.Rule{
+		Route:   req.Route,
+		UserID:  req.UserID,
+		Header:  req.Header,
+		Value:   req.Value,
+		Expires: time.Now().Add(ttl),
+	})
+	cc.logger.Warnf("Capturing traffic for rule %s until %s", rule.ID, rule.Expires.Format(time.RFC3339))
+
+	response.Success(c, rule)
+}
+
+// DeleteRule stops a capture rule
+func (cc *CaptureController) DeleteRule(c *gin.Context) {
+	if !cc.capture.Recorder().DeleteRule(c.Param("id")) {
+		response.Error(c, http.StatusNotFound, "Capture rule not found")
+		return
+	}
+
+	cc.logger.Infof("Deleted capture rule %s", c.Param("id"))
+	response.Success(c, nil)
+}
+
+// GetCaptures returns the captures kept in memory, optionally those of the rule given
+// as query parameter
+func (cc *CaptureController) GetCaptures(c *gin.Context) {
+	response.Success(c, cc.capture.Recorder().Records(c.Query("rule")))
+}
+
+// ClearCaptures drops the captures kept in memory
+func (cc *CaptureController) ClearCaptures(c *gin.Context) {
+	cc.capture.Recorder().Clear()
+	cc.logger.Infof("Cleared captures")
+	response.Success(c, nil)
+}
@@ -1,139 +1,64 @@
 package controller
 
 import (
-	"api-gateway-service-ms/config"
-	"api-gateway-service-ms/internal/pkg/cache"
+	"api-gateway-service-ms/internal/pkg/health"
 	"api-gateway-service-ms/internal/pkg/logger"
 	"api-gateway-service-ms/internal/pkg/response"
-	"context"
 	"net/http"
-	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/sirupsen/logrus"
 )
 
-// HealthController handles health check requests
+// HealthController handles the probes and health check requests
 type HealthController struct {
-	config *config.Config
-	cache  *cache.Cache
-	logger *logger.Logger
+	checker *health.Checker
+	logger  *logger.Logger
 }
 
-func NewHealthController(cfg *config.Config, cache *cache.Cache, logger *logger.Logger) *HealthController {
+func NewHealthController(checker *health.Checker, logger *logger.Logger) *HealthController {
 	return &HealthController{
-		config: cfg,
-		cache:  cache,
-		logger: logger,
+		checker: checker,
+		logger:  logger,
 	}
 }
 
-// CheckHealth handles the health check endpoint
-func (h *HealthController) CheckHealth(c *gin.Context) {
-	// Check Redis connection
-	redisStatus := "up"
-	redisError := ""
-
-	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
-	defer cancel()
-
-	if err := h.cache.Ping(ctx); err != nil {
-		redisStatus = "down"
-		redisError = err.Error()
-		h.logger.Errorf("Redis health check failed: %v", err)
-	}
-
-	// Check backend services
-	serviceStatuses := make(map[string]map[string]string)
-	var wg sync.WaitGroup
-	var mu sync.Mutex
-
-	for service, url := range h.config.FowardServiceUrl {
-		wg.Add(1)
-		go func(serviceName, serviceURL string) {
-			defer wg.Done()
-
-			status := "up"
-			statusCode := 0
-			responseTime := 0.0
-			errorMsg := ""
-
-			// Create context with timeout
-			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-			defer cancel()
-
-			// Create request
-			req, err := http.NewRequestWithContext(ctx, http.MethodGet, serviceURL+"/health", nil)
-			if err != nil {
-				status = "down"
-				errorMsg = err.Error()
-				logrus.Errorf("Error creating request for service %s: %v", serviceName, err)
-			} else {
-				// Measure response time
-				startTime := time.Now()
-
-				// Send request
-				client := &http.Client{}
-				resp, err := client.Do(req)
-
-				responseTime = time.Since(startTime).Seconds()
-
-				if err != nil {
-					status = "down"
-					errorMsg = err.Error()
-					logrus.Errorf("Error checking health of service %s: %v", serviceName, err)
-				} else {
-					defer resp.Body.Close()
-					statusCode = resp.StatusCode
-
-					if statusCode != http.StatusOK {
-						status = "degraded"
-						errorMsg = "Non-200 status code"
-						logrus.Warnf("Service %s health check returned status %d", serviceName, statusCode)
-					}
-				}
-			}
+// Live handles the liveness probe, answering as long as the process serves requests
+func (h *HealthController) Live(c *gin.Context) {
+	response.Success(c, gin.H{"status": health.STATUS_UP})
+}
 
-			// Store results
-			mu.Lock()
-			serviceStatuses[serviceName] = map[string]string{
-				"status":        status,
-				"statusCode":    http.StatusText(statusCode),
-				"responseTime":  time.Duration(responseTime * float64(time.Second)).String(),
-				"error":         errorMsg,
-				"lastCheckedAt": time.Now().Format(time.RFC3339),
-			}
-			mu.Unlock()
-		}(service, url)
+// Ready handles the readiness probe from the last dependency checks, with a 503 while
+// the gateway is starting, draining or missing a required dependency
+func (h *HealthController) Ready(c *gin.Context) {
+	if ready, reasons := h.checker.Ready(); !ready {
+		response.ErrorWithData(c, http.StatusServiceUnavailable, "Not ready", gin.H{
+			"status":  health.STATUS_DOWN,
+			"reasons": reasons,
+		})
+		return
 	}
 
-	// Wait for all checks to complete
-	wg.Wait()
+	response.Success(c, gin.H{"status": health.STATUS_UP})
+}
 
-	// Determine overall status
-	overallStatus := "up"
-	if redisStatus != "up" {
-		overallStatus = "degraded"
+// CheckHealth handles the detailed health check endpoint
+func (h *HealthController) CheckHealth(c *gin.Context) {
+	ready, reasons := h.checker.Ready()
+	data := gin.H{
+		"status":       h.checker.Status(),
+		"timestamp":    time.Now().Format(time.RFC3339),
+		"version":      "1.0.0",
+		"ready":        ready,
+		"reasons":      reasons,
+		"draining":     h.checker.Draining(),
+		"dependencies": h.checker.Snapshot(),
 	}
 
-	for _, status := range serviceStatuses {
-		if status["status"] == "down" {
-			overallStatus = "degraded"
-			break
-		}
+	if !ready {
+		response.ErrorWithData(c, http.StatusServiceUnavailable, "Unhealthy", data)
+		return
 	}
 
-	response.Success(c, map[string]interface{}{
-		"status":    overallStatus,
-		"timestamp": time.Now().Format(time.RFC3339),
-		"version":   "1.0.0",
-		"dependencies": map[string]interface{}{
-			"redis": map[string]interface{}{
-				"status": redisStatus,
-				"error":  redisError,
-			},
-			"services": serviceStatuses,
-		},
-	})
+	response.Success(c, data)
 }
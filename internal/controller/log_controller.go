@@ -0,0 +1,107 @@
+package controller
+
+import (
+	"api-gateway-service-ms/internal/pkg/logger"
+	"api-gateway-service-ms/internal/pkg/response"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	DEFAULT_DEBUG_TTL = 15 * time.Minute
+	MAX_DEBUG_TTL     = 24 * time.Hour
+)
+
+// LogController changes the log level and the debug rules at runtime
+type LogController struct {
+	logger *logger.Logger
+}
+
+func NewLogController(logger *logger.Logger) *LogController {
+	return &LogController{logger: logger}
+}
+
+type setLevelRequest struct {
+	Level string `json:"level" binding:"required"`
+}
+
+type addDebugRuleRequest struct {
+	Route  string `json:"route"`
+	UserID string `json:"user_id"`
+	// TTL is a duration such as "10m", 15 minutes by default
+	TTL string `json:"ttl"`
+}
+
+// GetLevel returns the global log level and the active debug rules
+func (lc *LogController) GetLevel(c *gin.Context) {
+	response.Success(c, map[string]interface{}{
+		"level":       lc.logger.GetLevel().String(),
+		"debug_rules": lc.logger.DebugRules(),
+	})
+}
+
+// SetLevel changes the global log level until the next restart, or the next reload
+// changing logging.level
+func (lc *LogController) SetLevel(c *gin.Context) {
+	var req setLevelRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.Error(c, http.StatusBadRequest, "level is required")
+		return
+	}
+
+	level, err := logrus.ParseLevel(req.Level)
+	if err != nil {
+		response.Error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	lc.logger.SetLevel(level)
+	lc.logger.Warnf("Log level changed to %s", level)
+	response.Success(c, map[string]interface{}{
+		"level": level.String(),
+	})
+}
+
+// AddDebugRule logs the requests to a route prefix and/or of a user at debug level for a while
+func (lc *LogController) AddDebugRule(c *gin.Context) {
+	var req addDebugRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.Error(c, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if req.Route == "" && req.UserID == "" {
+		response.Error(c, http.StatusBadRequest, "One of route or user_id is required")
+		return
+	}
+
+	ttl := DEFAULT_DEBUG_TTL
+	if req.TTL != "" {
+		var err error
+		ttl, err = time.ParseDuration(req.TTL)
+		if err != nil || ttl <= 0 || ttl > MAX_DEBUG_TTL {
+			response.Error(c, http.StatusBadRequest, "ttl must be a positive duration of at most 24h")
+			return
+		}
+	}
+
+	rule := logger.DebugRule{
+		Route:   req.Route,
+		UserID:  req.UserID,
+		Expires: time.Now().Add(ttl),
+	}
+	lc.logger.AddDebugRule(rule)
+	lc.logger.Warnf("Debug logging enabled for route %q and user %q until %s", rule.Route, rule.UserID, rule.Expires.Format(time.RFC3339))
+
+	response.Success(c, rule)
+}
+
+// ClearDebugRules removes every debug rule
+func (lc *LogController) ClearDebugRules(c *gin.Context) {
+	lc.logger.ClearDebugRules()
+	lc.logger.Warnf("Debug rules cleared")
+	response.Success(c, nil)
+}
@@ -0,0 +1,33 @@
+package controller
+
+import (
+	"api-gateway-service-ms/config"
+	"api-gateway-service-ms/internal/pkg/metrics"
+	"api-gateway-service-ms/internal/pkg/response"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// MetricsController exposes the gateway metrics to Prometheus
+type MetricsController struct {
+	config  *config.Manager
+	metrics *metrics.Metrics
+}
+
+func NewMetricsController(cfg *config.Manager, metrics *metrics.Metrics) *MetricsController {
+	return &MetricsController{
+		config:  cfg,
+		metrics: metrics,
+	}
+}
+
+// GetMetrics serves the metrics in the Prometheus exposition format
+func (mc *MetricsController) GetMetrics(c *gin.Context) {
+	if !mc.config.Get().Metrics.Enabled {
+		response.Error(c, http.StatusNotFound, "Metrics are disabled")
+		return
+	}
+
+	mc.metrics.Handler().ServeHTTP(c.Writer, c.Request)
+}
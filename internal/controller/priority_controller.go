@@ -0,0 +1,34 @@
+package controller
+
+import (
+	"api-gateway-service-ms/internal/middleware"
+	"api-gateway-service-ms/internal/pkg/logger"
+	"api-gateway-service-ms/internal/pkg/response"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// PriorityController exposes the priority scheduler counters for monitoring
+type PriorityController struct {
+	priority *middleware.PriorityMiddleware
+	logger   *logger.Logger
+}
+
+func NewPriorityController(priority *middleware.PriorityMiddleware, logger *logger.Logger) *PriorityController {
+	return &PriorityController{
+		priority: priority,
+		logger:   logger,
+	}
+}
+
+// GetStats returns the admitted, queued and shed counts per priority class
+func (p *PriorityController) GetStats(c *gin.Context) {
+	stats := p.priority.Stats()
+	if stats == nil {
+		response.Error(c, http.StatusNotFound, "Request prioritization is disabled")
+		return
+	}
+
+	response.Success(c, stats)
+}
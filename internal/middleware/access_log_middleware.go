@@ -0,0 +1,130 @@
+package middleware
+
+import (
+	"api-gateway-service-ms/config"
+	"api-gateway-service-ms/internal/pkg/accesslog"
+	"api-gateway-service-ms/internal/pkg/logger"
+	"crypto/tls"
+	"reflect"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ACCESS_LOG_CLOSE_DELAY keeps the sinks replaced by a reload open for requests still
+// holding the previous access logger
+const ACCESS_LOG_CLOSE_DELAY = 30 * time.Second
+
+type AccessLogMiddleware struct {
+	cfg       *config.Manager
+	logger    *logger.Logger
+	accessLog atomic.Pointer[accesslog.Logger]
+}
+
+func NewAccessLogMiddleware(cfg *config.Manager, logger *logger.Logger) *AccessLogMiddleware {
+	am := &AccessLogMiddleware{
+		cfg:    cfg,
+		logger: logger,
+	}
+
+	am.accessLog.Store(am.newAccessLog(cfg.Get()))
+
+	cfg.Subscribe(func(old, new *config.Config) {
+		if reflect.DeepEqual(old.AccessLog, new.AccessLog) {
+			return
+		}
+
+		previous := am.accessLog.Swap(am.newAccessLog(new))
+		if previous != nil {
+			time.AfterFunc(ACCESS_LOG_CLOSE_DELAY, func() { previous.Close() })
+		}
+		am.logger.Infof("Reopened access log after configuration change")
+	})
+
+	return am
+}
+
+// newAccessLog opens the configured sinks, or returns nil if the access log is disabled
+// or cannot be opened
+func (am *AccessLogMiddleware) newAccessLog(cfg *config.Config) *accesslog.Logger {
+	if !cfg.AccessLog.Enabled {
+		return nil
+	}
+
+	accessLog, err := accesslog.New(cfg.AccessLog)
+	if err != nil {
+		am.logger.Errorf("Access log disabled: %v", err)
+		return nil
+	}
+
+	return accessLog
+}
+
+// HandleAccessLog writes one access log line per request once it has been handled
+func (am *AccessLogMiddleware) HandleAccessLog() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		accessLog := am.accessLog.Load()
+		if accessLog == nil {
+			c.Next()
+			return
+		}
+
+		start := time.Now()
+		c.Next()
+
+		if err := accessLog.Log(am.newEntry(c, start)); err != nil {
+			am.logger.Errorf("Failed to write access log: %v", err)
+		}
+	}
+}
+
+func (am *AccessLogMiddleware) newEntry(c *gin.Context, start time.Time) *accesslog.Entry {
+	route, upstream := routeTemplate(c, am.cfg.Get())
+	redactor := am.logger.Redactor()
+	entry := &accesslog.Entry{
+		Time:        start,
+		RemoteAddr:  c.ClientIP(),
+		UserID:      c.GetString("user_id"),
+		Method:      c.Request.Method,
+		Path:        redactor.String(c.Request.URL.RequestURI()),
+		Protocol:    c.Request.Proto,
+		Status:      c.Writer.Status(),
+		BytesIn:     max(c.Request.ContentLength, 0),
+		BytesOut:    int64(max(c.Writer.Size(), 0)),
+		Duration:    milliseconds(time.Since(start)),
+		Referer:     redactor.String(c.Request.Referer()),
+		UserAgent:   c.Request.UserAgent(),
+		RequestID:   c.GetString(logger.FieldRequestID),
+		Route:       route,
+		Upstream:    upstream,
+		CacheStatus: c.Writer.Header().Get(X_CACHE),
+	}
+
+	if spanContext := trace.SpanContextFromContext(c.Request.Context()); spanContext.IsValid() {
+		entry.TraceID = spanContext.TraceID().String()
+	}
+
+	if c.Request.TLS != nil {
+		entry.TLSVersion = tls.VersionName(c.Request.TLS.Version)
+	}
+
+	entry.UpstreamAddr = c.GetString(accesslog.KeyUpstreamAddr)
+	if latency, ok := c.Get(accesslog.KeyUpstreamLatency); ok {
+		ms := milliseconds(latency.(time.Duration))
+		entry.UpstreamLatency = &ms
+	}
+
+	entry.RatelimitState = c.GetString(accesslog.KeyRatelimitState)
+	if remaining, ok := c.Get(accesslog.KeyRatelimitRemaining); ok {
+		remaining := remaining.(int)
+		entry.RatelimitRemaining = &remaining
+	}
+
+	return entry
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
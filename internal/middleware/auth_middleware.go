@@ -4,6 +4,7 @@ import (
 	"api-gateway-service-ms/config"
 	"api-gateway-service-ms/internal/pkg/logger"
 	"api-gateway-service-ms/internal/pkg/response"
+	"api-gateway-service-ms/internal/pkg/tracing"
 	"fmt"
 	"net/http"
 	"strings"
@@ -14,20 +15,24 @@ import (
 
 const (
 	BEARER_PREFIX = "Bearer"
+	// ADMIN_ROLE is the role claim required by the admin routes
+	ADMIN_ROLE = "admin"
 )
 
 // JWTClaims represents the claims in the JWT token
 type JWTClaims struct {
 	UserID string `json:"user_id"`
+	Role   string `json:"role,omitempty"`
+	Tier   string `json:"tier,omitempty"`
 	jwt.RegisteredClaims
 }
 
 type AuthMiddleware struct {
-	cfg    *config.Config
+	cfg    *config.Manager
 	logger *logger.Logger
 }
 
-func NewAuthMiddleware(cfg *config.Config, logger *logger.Logger) *AuthMiddleware {
+func NewAuthMiddleware(cfg *config.Manager, logger *logger.Logger) *AuthMiddleware {
 	return &AuthMiddleware{
 		cfg:    cfg,
 		logger: logger,
@@ -51,19 +56,75 @@ func (am *AuthMiddleware) HandleAuth() gin.HandlerFunc {
 			return
 		}
 
+		_, span := tracing.Start(c.Request.Context(), "auth")
 		claims, err := am.ValidateToken(tokenString)
+		tracing.EndWithError(span, err)
 		if err != nil {
 			response.Error(c, http.StatusUnauthorized, err.Error())
 			c.Abort()
 			return
 		}
 
-		// Set claims in the context for later use
-		c.Set("user_id", claims.UserID)
+		am.setIdentity(c, claims)
 		c.Next()
 	}
 }
 
+// HandleIdentity resolves the identity of requests carrying a valid token for the
+// middlewares keyed on the user, without rejecting the others. Invalid tokens are
+// left to the routes requiring authentication.
+func (am *AuthMiddleware) HandleIdentity() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		authHeader := c.GetHeader("Authorization")
+		if authHeader == "" {
+			c.Next()
+			return
+		}
+
+		tokenString, err := extractToken(authHeader)
+		if err != nil {
+			c.Next()
+			return
+		}
+
+		_, span := tracing.Start(c.Request.Context(), "auth")
+		claims, err := am.parseToken(tokenString)
+		tracing.EndWithError(span, err)
+		if err != nil {
+			am.logger.Debugf("Unresolved identity: %v", err)
+			c.Next()
+			return
+		}
+
+		am.setIdentity(c, claims)
+		c.Next()
+	}
+}
+
+// HandleAdmin rejects the requests whose verified identity doesn't have the admin role.
+// It must run after HandleAuth.
+func (am *AuthMiddleware) HandleAdmin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetString("user_role") != ADMIN_ROLE {
+			response.Error(c, http.StatusForbidden, "Admin role is required")
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// setIdentity sets the verified claims in the context for later use
+func (am *AuthMiddleware) setIdentity(c *gin.Context, claims *JWTClaims) {
+	c.Set("user_id", claims.UserID)
+	c.Set("user_role", claims.Role)
+	c.Set("user_tier", claims.Tier)
+	if am.logger.DebugEnabled(c.Request.URL.Path, claims.UserID) {
+		c.Request = c.Request.WithContext(logger.WithDebug(c.Request.Context()))
+	}
+}
+
 func extractToken(authHeader string) (string, error) {
 	parts := strings.Split(authHeader, " ")
 	if len(parts) != 2 || parts[0] != BEARER_PREFIX {
@@ -78,18 +139,28 @@ func extractToken(authHeader string) (string, error) {
 }
 
 func (am *AuthMiddleware) ValidateToken(tokenString string) (*JWTClaims, error) {
+	claims, err := am.parseToken(tokenString)
+	if err != nil {
+		am.logger.Errorf("Error parsing JWT token: %v", err)
+		return nil, fmt.Errorf("invalid or expired token")
+	}
+
+	return claims, nil
+}
+
+// parseToken validates the token and returns its claims without logging
+func (am *AuthMiddleware) parseToken(tokenString string) (*JWTClaims, error) {
 	// Parse and validate the token
 	token, err := jwt.ParseWithClaims(tokenString, &JWTClaims{}, func(token *jwt.Token) (interface{}, error) {
 		// Validate the signing method
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, jwt.ErrSignatureInvalid
 		}
-		return []byte(am.cfg.Auth.JWTSecret), nil
+		return []byte(am.cfg.Get().Auth.JWTSecret), nil
 	})
 
 	if err != nil {
-		am.logger.Errorf("Error parsing JWT token: %v", err)
-		return nil, fmt.Errorf("invalid or expired token")
+		return nil, err
 	}
 
 	// Check if the token is valid
@@ -0,0 +1,146 @@
+package middleware
+
+import (
+	"api-gateway-service-ms/config"
+	"api-gateway-service-ms/internal/pkg/accesslog"
+	"api-gateway-service-ms/internal/pkg/capture"
+	"api-gateway-service-ms/internal/pkg/logger"
+	"bytes"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const CAPTURE_MAX_BODY_SIZE = 64 << 10
+
+type CaptureMiddleware struct {
+	cfg      *config.Manager
+	logger   *logger.Logger
+	recorder *capture.Recorder
+}
+
+func NewCaptureMiddleware(cfg *config.Manager, logger *logger.Logger) *CaptureMiddleware {
+	captureConfig := cfg.Get().Capture
+	recorder := capture.NewRecorder(captureConfig.BufferSize, captureConfig.Retention)
+
+	if captureConfig.File.Path != "" {
+		file, err := accesslog.NewRotatingFile(captureConfig.File.Path, captureConfig.File.MaxSize, captureConfig.File.MaxBackups)
+		if err != nil {
+			logger.Errorf("Capture file disabled, keeping captures in memory only: %v", err)
+		} else {
+			recorder.SetFile(file)
+		}
+	}
+
+	return &CaptureMiddleware{
+		cfg:      cfg,
+		logger:   logger,
+		recorder: recorder,
+	}
+}
+
+// Recorder returns the capture rules and captures
+func (cm *CaptureMiddleware) Recorder() *capture.Recorder {
+	return cm.recorder
+}
+
+// HandleCapture records the request and response when a capture rule matches
+func (cm *CaptureMiddleware) HandleCapture() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !cm.cfg.Get().Capture.Enabled {
+			c.Next()
+			return
+		}
+
+		// The identity middleware has verified the caller by now
+		userID := c.GetString("user_id")
+		rule := cm.recorder.Match(c.Request, userID)
+		if rule == nil {
+			c.Next()
+			return
+		}
+
+		start := time.Now()
+		maxSize := cm.maxBodySize()
+		requestHeaders := c.Request.Header.Clone()
+		requestBody, requestTruncated, err := peekBody(c, maxSize)
+		if err != nil {
+			cm.logger.Errorf("Failed to capture request body: %v", err)
+			c.Next()
+			return
+		}
+
+		writer := &responseBodyWriter{
+			ResponseWriter: c.Writer,
+			body:           &bytes.Buffer{},
+			maxSize:        maxSize,
+			keepPrefix:     true,
+		}
+		c.Writer = writer
+		c.Next()
+		c.Writer = writer.ResponseWriter
+
+		redactor := cm.logger.Redactor()
+		responseHeaders := c.Writer.Header()
+		record := &capture.Record{
+			RuleID:          rule.ID,
+			Time:            start,
+			Duration:        milliseconds(time.Since(start)),
+			RequestID:       c.GetString(logger.FieldRequestID),
+			RemoteAddr:      c.ClientIP(),
+			UserID:          userID,
+			Method:          c.Request.Method,
+			URL:             redactor.String(c.Request.URL.RequestURI()),
+			RequestHeaders:  redactor.Header(requestHeaders),
+			RequestBody:     redactor.Mask(requestHeaders.Get("Content-Type"), requestBody),
+			RequestTrunc:    requestTruncated,
+			Status:          c.Writer.Status(),
+			ResponseHeaders: redactor.Header(responseHeaders),
+			ResponseBody:    redactor.Mask(responseHeaders.Get("Content-Type"), writer.body.Bytes()),
+			ResponseTrunc:   writer.truncated,
+		}
+
+		if err := cm.recorder.Add(record); err != nil {
+			cm.logger.Errorf("Failed to write capture: %v", err)
+		}
+	}
+}
+
+// peekBody returns up to maxSize bytes of the request body and leaves the full body
+// readable by the next handlers
+func peekBody(c *gin.Context, maxSize int64) ([]byte, bool, error) {
+	if c.Request.Body == nil || c.Request.Body == http.NoBody {
+		return nil, false, nil
+	}
+
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, maxSize+1))
+	if err != nil {
+		return nil, false, err
+	}
+
+	c.Request.Body = readCloser{
+		Reader: io.MultiReader(bytes.NewReader(body), c.Request.Body),
+		Closer: c.Request.Body,
+	}
+
+	if int64(len(body)) > maxSize {
+		return body[:maxSize], true, nil
+	}
+
+	return body, false, nil
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+func (cm *CaptureMiddleware) maxBodySize() int64 {
+	if cm.cfg.Get().Capture.MaxBodySize > 0 {
+		return cm.cfg.Get().Capture.MaxBodySize
+	}
+
+	return CAPTURE_MAX_BODY_SIZE
+}
@@ -1,52 +1,112 @@
 package middleware
 
 import (
+	"api-gateway-service-ms/config"
 	"api-gateway-service-ms/internal/pkg/cache"
 	"api-gateway-service-ms/internal/pkg/logger"
+	"api-gateway-service-ms/internal/pkg/metrics"
 	"api-gateway-service-ms/internal/pkg/response"
+	"api-gateway-service-ms/internal/pkg/tracing"
 	"bytes"
 	"context"
 	"crypto/sha256"
 	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 const (
 	X_IDEMPOTENCY_KEY = "X-Idempotency-Key"
 	IDEMPOTENCY_TTL   = 24 * time.Hour
+
+	IDEMPOTENCY_LOCK_TTL      = 10 * time.Second
+	IDEMPOTENCY_WAIT_TIMEOUT  = 30 * time.Second
+	IDEMPOTENCY_POLL_INTERVAL = 50 * time.Millisecond
+
+	IDEMPOTENCY_POLICY_REJECT = "reject"
+	IDEMPOTENCY_POLICY_WAIT   = "wait"
+
+	IDEMPOTENCY_ANONYMOUS_SCOPE = "anonymous"
+	IDEMPOTENCY_MAX_BODY_SIZE   = 1 << 20
+
+	// Per-route idempotency modes
+	IDEMPOTENCY_MODE_DISABLED = "disabled"
+	IDEMPOTENCY_MODE_REQUIRED = "required"
+	IDEMPOTENCY_MODE_OPTIONAL = "optional"
+	IDEMPOTENCY_MODE_AUTO     = "auto"
+
+	IDEMPOTENCY_AUTO_WINDOW = time.Minute
 )
 
 type CachedResponse struct {
-	StatusCode int               `json:"status_code"`
-	Body       []byte            `json:"body"`
-	Headers    map[string]string `json:"headers"`
+	StatusCode      int                 `json:"status_code"`
+	Body            []byte              `json:"body"`
+	Headers         map[string][]string `json:"headers"`
+	ContentType     string              `json:"content_type"`
+	ContentEncoding string              `json:"content_encoding"`
+	// Fingerprint identifies the request that produced the response
+	Fingerprint string `json:"fingerprint"`
+}
+
+// MarshalBinary lets the response be stored in the cache as JSON
+func (cr CachedResponse) MarshalBinary() ([]byte, error) {
+	return json.Marshal(cr)
 }
 
 // responseBodyWriter is a custom response writer that captures the response body
+// up to maxSize bytes, flagging the capture as truncated past that point. The partial
+// body is dropped unless keepPrefix is set.
 type responseBodyWriter struct {
 	gin.ResponseWriter
-	body *bytes.Buffer
+	body       *bytes.Buffer
+	maxSize    int64
+	truncated  bool
+	keepPrefix bool
 }
 
 func (r *responseBodyWriter) Write(b []byte) (int, error) {
-	r.body.Write(b)
+	if !r.truncated {
+		if r.maxSize > 0 && int64(r.body.Len()+len(b)) > r.maxSize {
+			r.truncated = true
+			if r.keepPrefix {
+				r.body.Write(b[:r.maxSize-int64(r.body.Len())])
+			} else {
+				r.body.Reset()
+			}
+		} else {
+			r.body.Write(b)
+		}
+	}
+
 	return r.ResponseWriter.Write(b)
 }
 
+func (r *responseBodyWriter) WriteString(s string) (int, error) {
+	return r.Write([]byte(s))
+}
+
 type IdempotencyMiddleware struct {
-	cache  *cache.Cache
-	logger *logger.Logger
+	cache   cache.Storage
+	logger  *logger.Logger
+	cfg     *config.Manager
+	metrics *metrics.Metrics
 }
 
-func NewIdempotencyMiddleware(cache *cache.Cache, logger *logger.Logger) *IdempotencyMiddleware {
+func NewIdempotencyMiddleware(cache cache.Storage, logger *logger.Logger, cfg *config.Manager, metrics *metrics.Metrics) *IdempotencyMiddleware {
 	return &IdempotencyMiddleware{
-		cache:  cache,
-		logger: logger,
+		cache:   cache,
+		logger:  logger,
+		cfg:     cfg,
+		metrics: metrics,
 	}
 }
 
@@ -57,54 +117,95 @@ func (im *IdempotencyMiddleware) HandleIdempotency() gin.HandlerFunc {
 			return
 		}
 
-		idempotencyKey := c.Request.Header.Get(X_IDEMPOTENCY_KEY)
+		route := im.routeConfig(c.Request.URL.Path)
+		mode := im.mode(route)
+		if mode == IDEMPOTENCY_MODE_DISABLED {
+			c.Next()
+			return
+		}
+
+		idempotencyKey := c.Request.Header.Get(im.header())
 		if idempotencyKey == "" {
-			var err error
-			idempotencyKey, err = generateIdempotencyKey(c)
+			switch mode {
+			case IDEMPOTENCY_MODE_REQUIRED:
+				response.Error(c, http.StatusBadRequest, fmt.Sprintf("%s header is required", im.header()))
+				c.Abort()
+				return
+			case IDEMPOTENCY_MODE_OPTIONAL:
+				c.Next()
+				return
+			}
+		}
+
+		fingerprint, err := requestFingerprint(c)
+		if err != nil {
+			im.logger.Errorf("Failed to fingerprint request: %v", err)
+			c.Next()
+			return
+		}
+
+		ttl := im.ttl(route)
+		if idempotencyKey == "" {
+			// Auto mode: the derived key is the fingerprint of the selected request parts
+			idempotencyKey, err = deriveIdempotencyKey(c, route)
 			if err != nil {
 				im.logger.Errorf("Failed to generate idempotency key: %v", err)
 				c.Next()
 				return
 			}
+
+			fingerprint = idempotencyKey
+			ttl = im.window(route)
 		}
 
-		cacheKey := fmt.Sprintf("idempotency:%s:%s", c.Request.Method, idempotencyKey)
-		ctx := context.Background()
+		// Scope keys per user so two users can't collide on the same key. The response and
+		// lock keys share a hash tag so they live on the same slot in Redis Cluster.
+		tag := cache.HashTag(fmt.Sprintf("%s:%s:%s", idempotencyScope(c), c.Request.Method, idempotencyKey))
+		cacheKey := fmt.Sprintf("idempotency:%s", tag)
+		// Storing the response and releasing the lock must outlive a disconnected client
+		ctx := context.WithoutCancel(c.Request.Context())
 
+		lookupCtx, span := tracing.Start(ctx, "idempotency lookup")
 		var cachedResponse CachedResponse
-		if err := im.cache.Get(ctx, cacheKey, &cachedResponse); err == nil {
+		if err := im.cache.Get(lookupCtx, cacheKey, &cachedResponse); err == nil {
+			span.SetAttributes(attribute.Bool("idempotency.hit", true))
+			span.End()
 			im.logger.Infof("Cache hit for idempotency key: %s", idempotencyKey)
-			for k, v := range cachedResponse.Headers {
-				c.Header(k, v)
-			}
-
-			c.Header("X-Idempotency-Hit", "true")
-
-			c.Data(cachedResponse.StatusCode, "application/json", cachedResponse.Body)
+			im.replayResponse(c, &cachedResponse, fingerprint)
+			return
+		}
+		span.SetAttributes(attribute.Bool("idempotency.hit", false))
+		span.End()
+
+		// Take a distributed lock so concurrent requests with the same key run the backend call once
+		lockKey := fmt.Sprintf("idempotency_lock:%s", tag)
+		lockCtx, span := tracing.Start(ctx, "idempotency lock")
+		lock, err := im.acquireLock(lockCtx, c, cacheKey, lockKey, fingerprint)
+		tracing.EndWithError(span, err)
+		if err != nil {
+			im.logger.Errorf("Failed to acquire lock for idempotency key %s: %v", idempotencyKey, err)
+			response.Error(c, http.StatusServiceUnavailable, "Idempotency store is unavailable")
 			c.Abort()
 			return
 		}
 
-		// Use a lock to prevent race conditions with concurrent requests using the same idempotency key
-		lockKey := fmt.Sprintf("idempotency_lock:%s:%s", c.Request.Method, idempotencyKey)
-		if err := im.cache.Set(ctx, lockKey, true, 10*time.Second); err != nil {
-			im.logger.Warnf("Failed to set lock for idempotency key: %v", err)
-
-			response.Error(
-				c,
-				http.StatusConflict,
-				"A request with the same idempotency key is already being processed",
-			)
-
-			c.Abort()
+		if lock == nil {
+			// The duplicate was rejected or replayed while waiting
 			return
 		}
-		defer im.cache.Delete(ctx, lockKey)
+		defer lock.Release(ctx)
+
+		// Keep extending the lock while the request is in flight
+		stopKeepAlive := lock.KeepAlive(ctx, func(err error) {
+			im.logger.Warnf("Lost lock for idempotency key %s: %v", idempotencyKey, err)
+		})
+		defer stopKeepAlive()
 
 		// Create a response writer that captures the response
 		writer := &responseBodyWriter{
 			ResponseWriter: c.Writer,
 			body:           bytes.NewBufferString(""),
+			maxSize:        im.maxBodySize(),
 		}
 		c.Writer = writer
 
@@ -112,39 +213,234 @@ func (im *IdempotencyMiddleware) HandleIdempotency() gin.HandlerFunc {
 		c.Next()
 
 		// Don't cache failed requests
-		if c.Writer.Status() >= http.StatusInternalServerError {
+		status := c.Writer.Status()
+		if status >= http.StatusInternalServerError ||
+			(status >= http.StatusBadRequest && !im.cacheClientErrors(route)) {
 			im.logger.Warnf(
 				"Not caching response with status %d for idempotency key: %s",
-				c.Writer.Status(), idempotencyKey,
+				status, idempotencyKey,
 			)
 
 			return
 		}
 
-		// After the request is processed, cache the response
-		headers := make(map[string]string)
-		for k, v := range c.Writer.Header() {
-			if len(v) > 0 {
-				headers[k] = v[0]
-			}
+		if writer.truncated {
+			im.logger.Warnf(
+				"Not caching response larger than %d bytes for idempotency key: %s",
+				writer.maxSize, idempotencyKey,
+			)
+
+			return
 		}
 
+		// After the request is processed, cache the response with every header value
+		headers := c.Writer.Header().Clone()
 		cachedResponse = CachedResponse{
-			StatusCode: c.Writer.Status(),
-			Headers:    headers,
-			Body:       writer.body.Bytes(),
+			StatusCode:      status,
+			Headers:         headers,
+			Body:            writer.body.Bytes(),
+			ContentType:     headers.Get("Content-Type"),
+			ContentEncoding: headers.Get("Content-Encoding"),
+			Fingerprint:     fingerprint,
 		}
 
 		// Store the response in cache
-		if err := im.cache.Set(ctx, cacheKey, cachedResponse, IDEMPOTENCY_TTL); err != nil {
+		storeCtx, span := tracing.Start(ctx, "idempotency store")
+		err = im.cache.Set(storeCtx, cacheKey, cachedResponse, ttl)
+		tracing.EndWithError(span, err)
+		if err != nil {
 			im.logger.Errorf("Failed to cache response for idempotency key %s: %v",
 				idempotencyKey, err)
 		} else {
+			im.metrics.Idempotency(metrics.IDEMPOTENCY_STORED)
 			im.logger.Infof("Cached response for idempotency key: %s", idempotencyKey)
 		}
+	}
+}
 
-		c.Next()
+// acquireLock returns the lock when this request should run. When a request with the
+// same key is already in flight it either rejects the duplicate with 409 or waits and
+// replays the first response; in both cases the response is written and the lock is nil.
+func (im *IdempotencyMiddleware) acquireLock(ctx context.Context, c *gin.Context, cacheKey, lockKey, fingerprint string) (*cache.Lock, error) {
+	lockTTL := im.cfg.Get().Idempotency.LockTTL
+	if lockTTL <= 0 {
+		lockTTL = IDEMPOTENCY_LOCK_TTL
+	}
+
+	waitTimeout := im.cfg.Get().Idempotency.WaitTimeout
+	if waitTimeout <= 0 {
+		waitTimeout = IDEMPOTENCY_WAIT_TIMEOUT
+	}
+	deadline := time.Now().Add(waitTimeout)
+
+	for {
+		lock, err := cache.AcquireLock(ctx, im.cache, lockKey, lockTTL)
+		if err == nil {
+			// The request holding the lock before may have stored its response between
+			// the lookup, or the last poll, and its release
+			var cachedResponse CachedResponse
+			if err := im.cache.Get(ctx, cacheKey, &cachedResponse); err == nil {
+				lock.Release(ctx)
+				im.replayResponse(c, &cachedResponse, fingerprint)
+				return nil, nil
+			}
+
+			return lock, nil
+		}
+
+		if !errors.Is(err, cache.ErrLockNotAcquired) {
+			return nil, err
+		}
+
+		if im.cfg.Get().Idempotency.ConcurrentPolicy != IDEMPOTENCY_POLICY_WAIT {
+			im.metrics.Idempotency(metrics.IDEMPOTENCY_CONFLICT)
+			response.Error(
+				c,
+				http.StatusConflict,
+				"A request with the same idempotency key is already being processed",
+			)
+
+			c.Abort()
+			return nil, nil
+		}
+
+		// Wait for the first request to finish and replay its response. If it finished
+		// without caching a response (e.g. a 5xx), try to take the lock ourselves.
+		for held := true; held; {
+			if time.Now().After(deadline) {
+				im.metrics.Idempotency(metrics.IDEMPOTENCY_CONFLICT)
+				response.Error(
+					c,
+					http.StatusConflict,
+					"Timed out waiting for a request with the same idempotency key",
+				)
+
+				c.Abort()
+				return nil, nil
+			}
+
+			select {
+			case <-c.Request.Context().Done():
+				c.Abort()
+				return nil, nil
+			case <-time.After(IDEMPOTENCY_POLL_INTERVAL):
+			}
+
+			var cachedResponse CachedResponse
+			if err := im.cache.Get(ctx, cacheKey, &cachedResponse); err == nil {
+				im.replayResponse(c, &cachedResponse, fingerprint)
+				return nil, nil
+			}
+
+			held, err = im.cache.Exists(ctx, lockKey)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+// replayResponse writes the cached response, or 422 if the key was reused for a different request
+func (im *IdempotencyMiddleware) replayResponse(c *gin.Context, cachedResponse *CachedResponse, fingerprint string) {
+	if cachedResponse.Fingerprint != fingerprint {
+		im.metrics.Idempotency(metrics.IDEMPOTENCY_MISMATCH)
+		response.Error(
+			c,
+			http.StatusUnprocessableEntity,
+			"Idempotency key was already used with a different request payload",
+		)
+
+		c.Abort()
+		return
 	}
+
+	// Headers already set for this request (request ID, rate limit, ...) take precedence
+	header := c.Writer.Header()
+	for k, v := range cachedResponse.Headers {
+		if _, exists := header[k]; !exists {
+			header[k] = v
+		}
+	}
+
+	if cachedResponse.ContentEncoding != "" {
+		header.Set("Content-Encoding", cachedResponse.ContentEncoding)
+	}
+
+	c.Header("X-Idempotency-Hit", "true")
+	im.metrics.Idempotency(metrics.IDEMPOTENCY_REPLAYED)
+
+	c.Data(cachedResponse.StatusCode, cachedResponse.ContentType, cachedResponse.Body)
+	c.Abort()
+}
+
+// routeConfig returns the settings of the longest configured path prefix matching the path
+func (im *IdempotencyMiddleware) routeConfig(path string) *config.IdempotencyRouteConfig {
+	routes := im.cfg.Get().Idempotency.Routes
+
+	var matched *config.IdempotencyRouteConfig
+	for i, route := range routes {
+		if strings.HasPrefix(path, route.Path) && (matched == nil || len(route.Path) > len(matched.Path)) {
+			matched = &routes[i]
+		}
+	}
+
+	return matched
+}
+
+func (im *IdempotencyMiddleware) mode(route *config.IdempotencyRouteConfig) string {
+	if route != nil && route.Mode != "" {
+		return route.Mode
+	}
+
+	if im.cfg.Get().Idempotency.Mode != "" {
+		return im.cfg.Get().Idempotency.Mode
+	}
+
+	return IDEMPOTENCY_MODE_OPTIONAL
+}
+
+func (im *IdempotencyMiddleware) header() string {
+	if im.cfg.Get().Idempotency.Header != "" {
+		return im.cfg.Get().Idempotency.Header
+	}
+
+	return X_IDEMPOTENCY_KEY
+}
+
+func (im *IdempotencyMiddleware) window(route *config.IdempotencyRouteConfig) time.Duration {
+	if route != nil && route.Window > 0 {
+		return route.Window
+	}
+
+	return IDEMPOTENCY_AUTO_WINDOW
+}
+
+func (im *IdempotencyMiddleware) ttl(route *config.IdempotencyRouteConfig) time.Duration {
+	if route != nil && route.TTL > 0 {
+		return route.TTL
+	}
+
+	if im.cfg.Get().Idempotency.TTL > 0 {
+		return im.cfg.Get().Idempotency.TTL
+	}
+
+	return IDEMPOTENCY_TTL
+}
+
+func (im *IdempotencyMiddleware) cacheClientErrors(route *config.IdempotencyRouteConfig) bool {
+	if route != nil && route.CacheClientErrors != nil {
+		return *route.CacheClientErrors
+	}
+
+	return im.cfg.Get().Idempotency.CacheClientErrors
+}
+
+func (im *IdempotencyMiddleware) maxBodySize() int64 {
+	if im.cfg.Get().Idempotency.MaxBodySize > 0 {
+		return im.cfg.Get().Idempotency.MaxBodySize
+	}
+
+	return IDEMPOTENCY_MAX_BODY_SIZE
 }
 
 func isIdempotentMethod(method string) bool {
@@ -152,14 +448,15 @@ func isIdempotentMethod(method string) bool {
 		method == http.MethodPatch || method == http.MethodDelete
 }
 
-func generateIdempotencyKey(c *gin.Context) (string, error) {
+// requestFingerprint hashes the method, path, user and body hash of the request
+func requestFingerprint(c *gin.Context) (string, error) {
 	hasher := sha256.New()
 
 	// Add method and path
 	hasher.Write([]byte(c.Request.Method + ":" + c.Request.URL.Path))
 
-	if userId, exists := c.Get("user_id"); exists {
-		hasher.Write([]byte(fmt.Sprintf("user_id:%s", userId)))
+	if userID := c.GetString("user_id"); userID != "" {
+		hasher.Write([]byte(fmt.Sprintf("user_id:%s", userID)))
 	}
 
 	if c.Request.Body != nil {
@@ -169,8 +466,83 @@ func generateIdempotencyKey(c *gin.Context) (string, error) {
 		}
 
 		c.Request.Body = io.NopCloser(bytes.NewBuffer(bodyBytes))
-		hasher.Write(bodyBytes)
+		bodyHash := sha256.Sum256(bodyBytes)
+		hasher.Write([]byte("body:" + hex.EncodeToString(bodyHash[:])))
+	}
+
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// deriveIdempotencyKey hashes the method, path, user and the request parts selected by
+// the route. Without selected body fields the whole body is used.
+func deriveIdempotencyKey(c *gin.Context, route *config.IdempotencyRouteConfig) (string, error) {
+	var keyHeaders, keyBodyFields []string
+	if route != nil {
+		keyHeaders, keyBodyFields = route.KeyHeaders, route.KeyBodyFields
+	}
+
+	if len(keyBodyFields) == 0 && len(keyHeaders) == 0 {
+		return requestFingerprint(c)
+	}
+
+	hasher := sha256.New()
+	hasher.Write([]byte(c.Request.Method + ":" + c.Request.URL.Path))
+
+	if userID := c.GetString("user_id"); userID != "" {
+		hasher.Write([]byte(fmt.Sprintf("user_id:%s", userID)))
+	}
+
+	for _, header := range keyHeaders {
+		hasher.Write([]byte(fmt.Sprintf("header:%s=%s", http.CanonicalHeaderKey(header), c.GetHeader(header))))
+	}
+
+	if len(keyBodyFields) > 0 && c.Request.Body != nil {
+		bodyBytes, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			return "", err
+		}
+		c.Request.Body = io.NopCloser(bytes.NewBuffer(bodyBytes))
+
+		var body map[string]interface{}
+		if err := json.Unmarshal(bodyBytes, &body); err != nil {
+			return "", fmt.Errorf("request body is not a JSON object: %w", err)
+		}
+
+		fields := append([]string(nil), keyBodyFields...)
+		sort.Strings(fields)
+		for _, field := range fields {
+			value, err := json.Marshal(lookupJSONField(body, field))
+			if err != nil {
+				return "", err
+			}
+			hasher.Write([]byte(fmt.Sprintf("body:%s=%s", field, value)))
+		}
 	}
 
 	return hex.EncodeToString(hasher.Sum(nil)), nil
 }
+
+// lookupJSONField resolves a dotted path such as "order.id" in a decoded JSON object
+func lookupJSONField(body map[string]interface{}, path string) interface{} {
+	var current interface{} = body
+	for _, part := range strings.Split(path, ".") {
+		object, ok := current.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		current = object[part]
+	}
+
+	return current
+}
+
+// idempotencyScope returns the user verified by the identity middleware, which runs
+// first. Anonymous requests are scoped per client address so they can't replay each
+// other's responses.
+func idempotencyScope(c *gin.Context) string {
+	if userID := c.GetString("user_id"); userID != "" {
+		return "user:" + userID
+	}
+
+	return IDEMPOTENCY_ANONYMOUS_SCOPE + ":" + c.ClientIP()
+}
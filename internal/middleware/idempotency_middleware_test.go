@@ -0,0 +1,193 @@
+package middleware
+
+import (
+	"api-gateway-service-ms/internal/pkg/cache"
+	"api-gateway-service-ms/internal/pkg/metrics"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const IDEMPOTENCY_TEST_REQUESTS = 10
+
+func newTestIdempotency(t *testing.T, policy string) (*IdempotencyMiddleware, cache.Storage) {
+	t.Helper()
+
+	cfg := newTestConfig(t, "idempotency:\n  concurrent_policy: "+policy+"\n  wait_timeout: 5s\n")
+	log := newTestLogger()
+	store := cache.NewMemoryCache(log)
+
+	return NewIdempotencyMiddleware(store, log, cfg, metrics.New()), store
+}
+
+// sendConcurrently sends the same keyed request from several clients at once and
+// returns their responses once all are done
+func sendConcurrently(router http.Handler) []*httptest.ResponseRecorder {
+	recorders := make([]*httptest.ResponseRecorder, IDEMPOTENCY_TEST_REQUESTS)
+	start := make(chan struct{})
+
+	var wg sync.WaitGroup
+	for i := range recorders {
+		recorders[i] = httptest.NewRecorder()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req := httptest.NewRequest(http.MethodPost, "/orders", strings.NewReader(`{"amount":42}`))
+			req.Header.Set(X_IDEMPOTENCY_KEY, "order-1")
+			<-start
+			router.ServeHTTP(recorders[i], req)
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	return recorders
+}
+
+func newIdempotencyRouter(im *IdempotencyMiddleware, hits *atomic.Int64) *gin.Engine {
+	router := gin.New()
+	router.Use(im.HandleIdempotency())
+	router.POST("/orders", func(c *gin.Context) {
+		hits.Add(1)
+		// Keep the lock held while the duplicates arrive
+		time.Sleep(200 * time.Millisecond)
+		c.JSON(http.StatusCreated, gin.H{"id": "order-1"})
+	})
+
+	return router
+}
+
+func TestIdempotencyConcurrentReject(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	im, _ := newTestIdempotency(t, IDEMPOTENCY_POLICY_REJECT)
+
+	var hits atomic.Int64
+	recorders := sendConcurrently(newIdempotencyRouter(im, &hits))
+
+	if got := hits.Load(); got != 1 {
+		t.Fatalf("backend hit %d times, want 1", got)
+	}
+
+	statuses := make(map[int]int)
+	for _, recorder := range recorders {
+		statuses[recorder.Code]++
+	}
+	if statuses[http.StatusCreated] != 1 || statuses[http.StatusConflict] != IDEMPOTENCY_TEST_REQUESTS-1 {
+		t.Fatalf("got statuses %v, want one 201 and %d 409", statuses, IDEMPOTENCY_TEST_REQUESTS-1)
+	}
+}
+
+func TestIdempotencyConcurrentWaitReplays(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	im, _ := newTestIdempotency(t, IDEMPOTENCY_POLICY_WAIT)
+
+	var hits atomic.Int64
+	recorders := sendConcurrently(newIdempotencyRouter(im, &hits))
+
+	if got := hits.Load(); got != 1 {
+		t.Fatalf("backend hit %d times, want 1", got)
+	}
+
+	replayed := 0
+	for _, recorder := range recorders {
+		if recorder.Code != http.StatusCreated {
+			t.Fatalf("got status %d, want 201: %s", recorder.Code, recorder.Body.String())
+		}
+		if recorder.Body.String() != `{"id":"order-1"}` {
+			t.Fatalf("got body %q, want the first response", recorder.Body.String())
+		}
+		if recorder.Header().Get("X-Idempotency-Hit") == "true" {
+			replayed++
+		}
+	}
+	if replayed != IDEMPOTENCY_TEST_REQUESTS-1 {
+		t.Fatalf("%d responses replayed, want %d", replayed, IDEMPOTENCY_TEST_REQUESTS-1)
+	}
+}
+
+// A response stored between the lookup and the lock being released is replayed
+// instead of running the request again
+func TestIdempotencyAcquireLockReplaysStoredResponse(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	im, store := newTestIdempotency(t, IDEMPOTENCY_POLICY_WAIT)
+	ctx := context.Background()
+
+	stored := CachedResponse{
+		StatusCode:  http.StatusCreated,
+		Body:        []byte(`{"id":"order-1"}`),
+		ContentType: "application/json",
+		Fingerprint: "fingerprint",
+	}
+	if err := store.Set(ctx, "idempotency:order", stored, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(http.MethodPost, "/orders", nil)
+
+	lock, err := im.acquireLock(ctx, c, "idempotency:order", "idempotency_lock:order", "fingerprint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lock != nil {
+		t.Fatal("got the lock, want the stored response replayed")
+	}
+	if recorder.Code != http.StatusCreated || recorder.Body.String() != `{"id":"order-1"}` {
+		t.Fatalf("got %d %q, want the stored response", recorder.Code, recorder.Body.String())
+	}
+
+	if held, err := store.Exists(ctx, "idempotency_lock:order"); err != nil || held {
+		t.Fatalf("lock still held after replay (err %v)", err)
+	}
+}
+
+// The same key sent by different users, or anonymously from different clients, runs
+// the request for each of them
+func TestIdempotencyScopedPerIdentity(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	im, _ := newTestIdempotency(t, IDEMPOTENCY_POLICY_REJECT)
+
+	var hits atomic.Int64
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		// Stands in for the identity middleware
+		if userID := c.GetHeader("X-Test-User"); userID != "" {
+			c.Set("user_id", userID)
+		}
+	})
+	router.Use(im.HandleIdempotency())
+	router.POST("/orders", func(c *gin.Context) {
+		hits.Add(1)
+		c.JSON(http.StatusCreated, gin.H{"id": "order-1"})
+	})
+
+	send := func(userID, remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/orders", strings.NewReader(`{"amount":42}`))
+		req.Header.Set(X_IDEMPOTENCY_KEY, "order-1")
+		req.Header.Set("X-Test-User", userID)
+		req.RemoteAddr = remoteAddr
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	send("alice", "10.0.0.1:1000")
+	send("bob", "10.0.0.1:1000")
+	send("", "10.0.0.2:1000")
+	send("", "10.0.0.3:1000")
+	if got := hits.Load(); got != 4 {
+		t.Fatalf("backend hit %d times, want once per identity", got)
+	}
+
+	if recorder := send("alice", "10.0.0.9:1000"); recorder.Header().Get("X-Idempotency-Hit") != "true" {
+		t.Fatal("retry of the same user wasn't replayed")
+	}
+}
@@ -0,0 +1,53 @@
+package middleware
+
+import (
+	"api-gateway-service-ms/config"
+	"api-gateway-service-ms/internal/pkg/response"
+	"context"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type listenerKey struct{}
+
+// WithListener returns a context carrying the name of the listener a request came from
+func WithListener(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, listenerKey{}, name)
+}
+
+// ListenerName returns the name of the listener a request came from
+func ListenerName(ctx context.Context) string {
+	name, _ := ctx.Value(listenerKey{}).(string)
+	return name
+}
+
+type ListenerMiddleware struct {
+	// listeners are the named listeners, the single listener of server.port serves every group
+	listeners map[string]config.ListenerConfig
+}
+
+// NewListenerMiddleware reads the route groups of the listeners, which are bound once at startup
+func NewListenerMiddleware(cfg *config.Manager) *ListenerMiddleware {
+	listeners := make(map[string]config.ListenerConfig)
+	for _, listener := range cfg.Get().Server.Listeners {
+		listeners[listener.Name] = listener
+	}
+
+	return &ListenerMiddleware{listeners: listeners}
+}
+
+// HandleListener serves the route group, one of the config.ROUTES_* values, on the listeners it is attached to, and answers
+// 404 on the others
+func (lm *ListenerMiddleware) HandleListener(group string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		listener, ok := lm.listeners[ListenerName(c.Request.Context())]
+		if ok && !listener.Serves(group) {
+			response.Error(c, http.StatusNotFound, "Not found")
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
@@ -0,0 +1,55 @@
+package middleware
+
+import (
+	"api-gateway-service-ms/config"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestListenerRouteGroups(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	cfg := newTestConfig(t, `server:
+  listeners:
+    - name: public
+      port: "8081"
+    - name: admin
+      port: "9090"
+      routes: [metrics, priority]
+`)
+	lm := NewListenerMiddleware(cfg)
+
+	router := gin.New()
+	for _, group := range []string{config.ROUTES_METRICS, config.ROUTES_PRIORITY, config.ROUTES_PROXY} {
+		router.GET("/"+group, lm.HandleListener(group), func(c *gin.Context) {
+			c.Status(http.StatusOK)
+		})
+	}
+
+	tests := []struct {
+		listener string
+		group    string
+		status   int
+	}{
+		{listener: "public", group: config.ROUTES_PROXY, status: http.StatusOK},
+		{listener: "public", group: config.ROUTES_METRICS, status: http.StatusNotFound},
+		{listener: "public", group: config.ROUTES_PRIORITY, status: http.StatusNotFound},
+		{listener: "admin", group: config.ROUTES_METRICS, status: http.StatusOK},
+		{listener: "admin", group: config.ROUTES_PRIORITY, status: http.StatusOK},
+		{listener: "admin", group: config.ROUTES_PROXY, status: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/"+tt.group, nil)
+		req = req.WithContext(WithListener(req.Context(), tt.listener))
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, req)
+
+		if recorder.Code != tt.status {
+			t.Errorf("%s on listener %s: got %d, want %d", tt.group, tt.listener, recorder.Code, tt.status)
+		}
+	}
+}
@@ -44,18 +44,29 @@ func (lm *LoggerMiddleware) HandleLogger() gin.HandlerFunc {
 			logger.FieldMethod: c.Request.Method,
 			logger.FieldPath:   c.Request.URL.Path,
 			logger.FieldIP:     c.ClientIP(),
-		})
+		}).WithFields(logger.TraceFields(c.Request.Context()))
 
 		// Add user ID if available
 		if userID, exists := c.Get("user_id"); exists {
 			logEntry = logEntry.WithField(logger.FieldUserID, userID)
 		}
 
+		// Log matching requests at debug level for live troubleshooting
+		if lm.logger.DebugEnabled(c.Request.URL.Path, c.GetString("user_id")) {
+			c.Request = c.Request.WithContext(logger.WithDebug(c.Request.Context()))
+			logEntry = lm.logger.Debugging(logEntry)
+		}
+
 		// Store the logger in the context
 		c.Set("logger", logEntry)
 
-		// Log request
-		logEntry.Info("Request started")
+		// Log request. With sampling the outcome isn't known yet, so only debugged
+		// requests log their start.
+		if lm.logger.SamplingEnabled() {
+			logEntry.Debug("Request started")
+		} else {
+			logEntry.Info("Request started")
+		}
 
 		// Process request
 		c.Next()
@@ -63,6 +74,16 @@ func (lm *LoggerMiddleware) HandleLogger() gin.HandlerFunc {
 		// Calculate request duration
 		duration := time.Since(start)
 
+		// The authentication may have matched a debug rule for the user
+		debug := logger.IsDebug(c.Request.Context())
+		if debug {
+			logEntry = lm.logger.Debugging(logEntry)
+		}
+
+		if !lm.logger.SampleRequest(c.Writer.Status(), duration, debug) {
+			return
+		}
+
 		// Update log entry with response info
 		logEntry = logEntry.WithFields(map[string]interface{}{
 			logger.FieldStatusCode: c.Writer.Status(),
@@ -0,0 +1,69 @@
+package middleware
+
+import (
+	"api-gateway-service-ms/config"
+	"api-gateway-service-ms/internal/pkg/metrics"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// METHOD_OTHER labels requests with a non-standard method, keeping cardinality bounded
+const METHOD_OTHER = "OTHER"
+
+type MetricsMiddleware struct {
+	cfg     *config.Manager
+	metrics *metrics.Metrics
+}
+
+func NewMetricsMiddleware(cfg *config.Manager, metrics *metrics.Metrics) *MetricsMiddleware {
+	return &MetricsMiddleware{
+		cfg:     cfg,
+		metrics: metrics,
+	}
+}
+
+// HandleMetrics records the count, latency and in-flight requests per route template
+func (mm *MetricsMiddleware) HandleMetrics() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		cfg := mm.cfg.Get()
+		if !cfg.Metrics.Enabled {
+			c.Next()
+			return
+		}
+
+		route, upstream := routeTemplate(c, cfg)
+		done := mm.metrics.StartRequest(route, methodLabel(c.Request.Method), upstream)
+		defer func() {
+			done(c.Writer.Status())
+		}()
+
+		c.Next()
+	}
+}
+
+// routeTemplate returns the matched gateway route, or the proxy route of the service
+// named by the first path segment. Raw paths are never used as labels.
+func routeTemplate(c *gin.Context, cfg *config.Config) (string, string) {
+	if route := c.FullPath(); route != "" {
+		return route, metrics.NO_UPSTREAM
+	}
+
+	service, _, _ := strings.Cut(strings.TrimPrefix(c.Request.URL.Path, "/"), "/")
+	if _, ok := cfg.FowardServiceUrl[service]; ok {
+		return "/" + service + "/*path", service
+	}
+
+	return metrics.ROUTE_UNMATCHED, metrics.NO_UPSTREAM
+}
+
+func methodLabel(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodOptions:
+		return method
+	default:
+		return METHOD_OTHER
+	}
+}
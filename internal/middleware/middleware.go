@@ -9,6 +9,13 @@ type Middleware struct {
 	logger      *LoggerMiddleware
 	auth        *AuthMiddleware
 	idempotency *IdempotencyMiddleware
+	priority    *PriorityMiddleware
+	cache       *ResponseCacheMiddleware
+	metrics     *MetricsMiddleware
+	tracing     *TracingMiddleware
+	accessLog   *AccessLogMiddleware
+	capture     *CaptureMiddleware
+	listener    *ListenerMiddleware
 }
 
 func NewMiddleware(
@@ -16,12 +23,26 @@ func NewMiddleware(
 	logger *LoggerMiddleware,
 	auth *AuthMiddleware,
 	idempotency *IdempotencyMiddleware,
+	priority *PriorityMiddleware,
+	cache *ResponseCacheMiddleware,
+	metrics *MetricsMiddleware,
+	tracing *TracingMiddleware,
+	accessLog *AccessLogMiddleware,
+	capture *CaptureMiddleware,
+	listener *ListenerMiddleware,
 ) *Middleware {
 	return &Middleware{
 		rateLimiter: rateLimiter,
 		logger:      logger,
 		auth:        auth,
 		idempotency: idempotency,
+		priority:    priority,
+		cache:       cache,
+		metrics:     metrics,
+		tracing:     tracing,
+		accessLog:   accessLog,
+		capture:     capture,
+		listener:    listener,
 	}
 }
 
@@ -33,6 +54,16 @@ func (m *Middleware) Authentication() gin.HandlerFunc {
 	return m.auth.HandleAuth()
 }
 
+// Identity resolves the user of requests with a valid token without requiring one
+func (m *Middleware) Identity() gin.HandlerFunc {
+	return m.auth.HandleIdentity()
+}
+
+// Admin requires the admin role, after Authentication
+func (m *Middleware) Admin() gin.HandlerFunc {
+	return m.auth.HandleAdmin()
+}
+
 func (m *Middleware) Idempotency() gin.HandlerFunc {
 	return m.idempotency.HandleIdempotency()
 }
@@ -40,3 +71,31 @@ func (m *Middleware) Idempotency() gin.HandlerFunc {
 func (m *Middleware) RateLimiter() gin.HandlerFunc {
 	return m.rateLimiter.HandleRateLimit()
 }
+
+func (m *Middleware) Priority() gin.HandlerFunc {
+	return m.priority.HandlePriority()
+}
+
+func (m *Middleware) ResponseCache() gin.HandlerFunc {
+	return m.cache.HandleResponseCache()
+}
+
+func (m *Middleware) Metrics() gin.HandlerFunc {
+	return m.metrics.HandleMetrics()
+}
+
+func (m *Middleware) Tracing() gin.HandlerFunc {
+	return m.tracing.HandleTracing()
+}
+
+func (m *Middleware) AccessLog() gin.HandlerFunc {
+	return m.accessLog.HandleAccessLog()
+}
+
+func (m *Middleware) Capture() gin.HandlerFunc {
+	return m.capture.HandleCapture()
+}
+
+func (m *Middleware) Listener(group string) gin.HandlerFunc {
+	return m.listener.HandleListener(group)
+}
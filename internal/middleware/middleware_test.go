@@ -0,0 +1,45 @@
+package middleware
+
+import (
+	"api-gateway-service-ms/config"
+	"api-gateway-service-ms/internal/pkg/logger"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Settings required for the configuration to be valid
+const (
+	TEST_BASE_CONFIG   = "cache:\n  driver: memory\nauth:\n  jwt_secret: secret\nratelimit:\n  limit: 100\n  period: 1m\n"
+	TEST_SERVER_CONFIG = "server:\n  port: \"8080\"\n"
+)
+
+// newTestConfig loads the base configuration followed by settings, with a single
+// listener unless settings declare the server
+func newTestConfig(t *testing.T, settings string) *config.Manager {
+	t.Helper()
+
+	if !strings.Contains(settings, "server:") {
+		settings = TEST_SERVER_CONFIG + settings
+	}
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, config.CONFIG_FILE), []byte(TEST_BASE_CONFIG+settings), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := config.NewManager(dir)
+	if err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+
+	return cfg
+}
+
+func newTestLogger() *logger.Logger {
+	return logger.New(logger.LoggerConfig{Level: logrus.ErrorLevel, Output: io.Discard})
+}
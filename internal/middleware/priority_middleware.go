@@ -0,0 +1,190 @@
+package middleware
+
+import (
+	"api-gateway-service-ms/config"
+	"api-gateway-service-ms/internal/pkg/logger"
+	"api-gateway-service-ms/internal/pkg/metrics"
+	"api-gateway-service-ms/internal/pkg/priority"
+	"api-gateway-service-ms/internal/pkg/response"
+	"errors"
+	"net/http"
+	"reflect"
+	"slices"
+	"strings"
+	"sync/atomic"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	DEFAULT_PRIORITY = "default"
+)
+
+type PriorityMiddleware struct {
+	cfg       *config.Manager
+	logger    *logger.Logger
+	metrics   *metrics.Metrics
+	scheduler atomic.Pointer[priority.Scheduler]
+}
+
+func NewPriorityMiddleware(cfg *config.Manager, logger *logger.Logger, metrics *metrics.Metrics) *PriorityMiddleware {
+	pm := &PriorityMiddleware{
+		cfg:     cfg,
+		logger:  logger,
+		metrics: metrics,
+	}
+
+	pm.scheduler.Store(pm.newScheduler(cfg.Get()))
+
+	// Requests admitted by the previous scheduler release their slots to it, so the
+	// new limits apply fully once they have drained
+	cfg.Subscribe(func(old, new *config.Config) {
+		if reflect.DeepEqual(old.Priority, new.Priority) {
+			return
+		}
+
+		pm.scheduler.Store(pm.newScheduler(new))
+		pm.logger.Infof("Rebuilt priority scheduler after configuration change")
+	})
+
+	return pm
+}
+
+// newScheduler builds a scheduler for the configured classes, or returns nil if
+// prioritization is disabled
+func (pm *PriorityMiddleware) newScheduler(cfg *config.Config) *priority.Scheduler {
+	if !cfg.Priority.Enabled {
+		return nil
+	}
+
+	defaultClass := pm.defaultClass(cfg)
+	classes := make([]priority.Class, 0, len(cfg.Priority.Classes)+1)
+	hasDefault := false
+	for _, class := range cfg.Priority.Classes {
+		classes = append(classes, priority.Class{Name: class.Name, Weight: class.Weight})
+		if class.Name == defaultClass {
+			hasDefault = true
+		}
+	}
+
+	if !hasDefault {
+		classes = append(classes, priority.Class{Name: defaultClass, Weight: 1})
+	}
+
+	return priority.NewScheduler(
+		cfg.Priority.MaxConcurrent,
+		cfg.Priority.MaxQueue,
+		cfg.Priority.QueueTimeout,
+		classes,
+	)
+}
+
+// HandlePriority classifies the request and waits for a slot in its priority class
+func (pm *PriorityMiddleware) HandlePriority() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		scheduler := pm.scheduler.Load()
+		if scheduler == nil {
+			c.Next()
+			return
+		}
+
+		className := pm.classify(c, pm.cfg.Get())
+		c.Set("priority_class", className)
+
+		release, err := scheduler.Acquire(c.Request.Context(), className)
+		if err != nil {
+			stats := scheduler.Stats().Classes[className]
+			pm.logger.Warnf("Shed request in priority class %s (total shed: %d): %v", className, stats.Shed, err)
+			pm.metrics.PriorityShed(className)
+
+			statusCode := http.StatusServiceUnavailable
+			if errors.Is(err, priority.ErrQueueTimeout) {
+				statusCode = http.StatusGatewayTimeout
+			}
+
+			c.Header("Retry-After", "1")
+			response.Error(c, statusCode, "Service is overloaded, please retry later")
+			c.Abort()
+			return
+		}
+		defer release()
+
+		c.Next()
+	}
+}
+
+// Stats returns the scheduler counters, or nil if prioritization is disabled
+func (pm *PriorityMiddleware) Stats() *priority.Stats {
+	scheduler := pm.scheduler.Load()
+	if scheduler == nil {
+		return nil
+	}
+
+	stats := scheduler.Stats()
+	return &stats
+}
+
+// classify returns the first configured class matching the request
+func (pm *PriorityMiddleware) classify(c *gin.Context, cfg *config.Config) string {
+	for _, class := range cfg.Priority.Classes {
+		if pm.matches(c, cfg, class) {
+			return class.Name
+		}
+	}
+
+	return pm.defaultClass(cfg)
+}
+
+func (pm *PriorityMiddleware) matches(c *gin.Context, cfg *config.Config, class config.PriorityClassConfig) bool {
+	if len(class.Routes) > 0 {
+		matched := slices.ContainsFunc(class.Routes, func(route string) bool {
+			return strings.HasPrefix(c.Request.URL.Path, route)
+		})
+		if !matched {
+			return false
+		}
+	}
+
+	for header, value := range class.Headers {
+		if c.GetHeader(header) != value {
+			return false
+		}
+	}
+
+	if len(class.Tiers) > 0 && !slices.Contains(class.Tiers, pm.tier(c, cfg)) {
+		return false
+	}
+
+	if class.Authenticated != nil && *class.Authenticated != isAuthenticated(c) {
+		return false
+	}
+
+	return true
+}
+
+func (pm *PriorityMiddleware) defaultClass(cfg *config.Config) string {
+	if cfg.Priority.DefaultClass != "" {
+		return cfg.Priority.DefaultClass
+	}
+
+	return DEFAULT_PRIORITY
+}
+
+// tier returns the tier claim of the verified identity. The tier header is only read
+// when configured, for deployments where a trusted proxy in front of the gateway sets it.
+func (pm *PriorityMiddleware) tier(c *gin.Context, cfg *config.Config) string {
+	if tier := c.GetString("user_tier"); tier != "" {
+		return tier
+	}
+	if cfg.Priority.TierHeader != "" {
+		return c.GetHeader(cfg.Priority.TierHeader)
+	}
+
+	return ""
+}
+
+// isAuthenticated reports whether the request carries an identity verified by the
+// auth middleware
+func isAuthenticated(c *gin.Context) bool {
+	return c.GetString("user_id") != ""
+}
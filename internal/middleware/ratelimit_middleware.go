@@ -2,35 +2,51 @@ package middleware
 
 import (
 	"api-gateway-service-ms/config"
+	"api-gateway-service-ms/internal/pkg/accesslog"
 	"api-gateway-service-ms/internal/pkg/cache"
 	"api-gateway-service-ms/internal/pkg/logger"
+	"api-gateway-service-ms/internal/pkg/metrics"
+	"api-gateway-service-ms/internal/pkg/response"
+	"api-gateway-service-ms/internal/pkg/tracing"
 	"context"
 	"fmt"
+	"math"
 	"net/http"
 	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 const (
 	RATELIMIT_HEADER    = "X-RateLimit-Limit"
 	RATELIMIT_REMAINING = "X-RateLimit-Remaining"
 	RATELIMIT_RESET     = "X-RateLimit-Reset"
+
+	// IETF draft-ietf-httpapi-ratelimit-headers fields
+	RATELIMIT_POLICY = "RateLimit-Policy"
+	RATELIMIT        = "RateLimit"
+	RETRY_AFTER      = "Retry-After"
+
+	RATELIMIT_HEADERS_LEGACY = "legacy"
+	RATELIMIT_HEADERS_IETF   = "ietf"
+	RATELIMIT_HEADERS_BOTH   = "both"
 )
 
 type RateLimiterMiddleware struct {
-	cache  *cache.Cache
-	logger *logger.Logger
-	cfg    *config.Config
+	cache   cache.Storage
+	logger  *logger.Logger
+	cfg     *config.Manager
+	metrics *metrics.Metrics
 }
 
-func NewRateLimiterMiddleware(cache *cache.Cache, logger *logger.Logger, cfg *config.Config) *RateLimiterMiddleware {
+func NewRateLimiterMiddleware(cache cache.Storage, logger *logger.Logger, cfg *config.Manager, metrics *metrics.Metrics) *RateLimiterMiddleware {
 	return &RateLimiterMiddleware{
-		cache:  cache,
-		logger: logger,
-		cfg:    cfg,
+		cache:   cache,
+		logger:  logger,
+		cfg:     cfg,
+		metrics: metrics,
 	}
 }
 
@@ -42,12 +58,17 @@ func (rl *RateLimiterMiddleware) HandleRateLimit() gin.HandlerFunc {
 			identifier = userID.(string)
 		}
 
-		key := fmt.Sprintf("ratelimit:%s", identifier)
-		ctx := context.Background()
+		key := fmt.Sprintf("ratelimit:%s", cache.HashTag(identifier))
+		// The counter update must not be abandoned when the client goes away
+		ctx, span := tracing.Start(context.WithoutCancel(c.Request.Context()), "ratelimit")
+		defer span.End()
 
 		count, isExceeded, err := rl.checkRateLimit(ctx, key)
+		span.SetAttributes(attribute.Int("ratelimit.count", count), attribute.Bool("ratelimit.exceeded", isExceeded))
 		if err != nil {
 			rl.logger.Errorf("Error checking rate limit: %v", err)
+			span.RecordError(err)
+			c.Set(accesslog.KeyRatelimitState, accesslog.RATELIMIT_ERROR)
 
 			c.Next()
 			return
@@ -55,18 +76,24 @@ func (rl *RateLimiterMiddleware) HandleRateLimit() gin.HandlerFunc {
 
 		if isExceeded {
 			ttl, err := rl.cache.TTL(ctx, key)
-			if err != nil {
-				rl.logger.Errorf("Error getting rate limit TTL: %v", err)
-				ttl = 0
+			if err != nil || ttl <= 0 {
+				if err != nil {
+					rl.logger.Errorf("Error getting rate limit TTL: %v", err)
+				}
+				ttl = rl.cfg.Get().Ratelimit.Period
 			}
 
-			c.Header(RATELIMIT_HEADER, strconv.Itoa(rl.cfg.Ratelimit.Limit))
-			c.Header(RATELIMIT_REMAINING, "0")
-			c.Header(RATELIMIT_RESET, strconv.FormatInt(time.Now().Add(ttl).Unix(), 10))
-
-			c.JSON(http.StatusTooManyRequests, gin.H{
-				"error":       "Rate limit exceeded",
-				"retry_after": ttl.Seconds(),
+			retryAfter := int64(math.Ceil(ttl.Seconds()))
+			rl.setRateLimitHeaders(c, 0, ttl)
+			c.Header(RETRY_AFTER, strconv.FormatInt(retryAfter, 10))
+			rl.metrics.RatelimitRejected()
+			c.Set(accesslog.KeyRatelimitState, accesslog.RATELIMIT_LIMITED)
+			c.Set(accesslog.KeyRatelimitRemaining, 0)
+
+			response.ErrorWithData(c, http.StatusTooManyRequests, "Rate limit exceeded", gin.H{
+				"limit":       rl.cfg.Get().Ratelimit.Limit,
+				"remaining":   0,
+				"retry_after": retryAfter,
 			})
 
 			c.Abort()
@@ -79,38 +106,68 @@ func (rl *RateLimiterMiddleware) HandleRateLimit() gin.HandlerFunc {
 			rl.logger.Errorf("Error incrementing rate limit count: %v", err)
 		}
 
-		// Set rate limit headers
-		c.Header(RATELIMIT_HEADER, strconv.Itoa(rl.cfg.Ratelimit.Limit))
-		c.Header(RATELIMIT_REMAINING, strconv.Itoa(rl.cfg.Ratelimit.Limit-count-1))
-
-		// Get TTL for the key
+		// Get TTL for the key and set rate limit headers
 		ttl, err := rl.cache.TTL(ctx, key)
 		if err != nil {
 			rl.logger.Errorf("Error getting rate limit TTL: %v", err)
-		} else {
-			c.Header(RATELIMIT_RESET, strconv.FormatInt(time.Now().Add(ttl).Unix(), 10))
+			ttl = -1
 		}
+		remaining := max(rl.cfg.Get().Ratelimit.Limit-count-1, 0)
+		rl.setRateLimitHeaders(c, remaining, ttl)
+		c.Set(accesslog.KeyRatelimitState, accesslog.RATELIMIT_ALLOWED)
+		c.Set(accesslog.KeyRatelimitRemaining, remaining)
 
 		c.Next()
 	}
 }
 
+// setRateLimitHeaders writes the legacy X-RateLimit-* and/or the IETF
+// RateLimit-Policy and RateLimit fields. A negative ttl omits the reset value.
+func (rl *RateLimiterMiddleware) setRateLimitHeaders(c *gin.Context, remaining int, ttl time.Duration) {
+	limit := rl.cfg.Get().Ratelimit.Limit
+	remaining = max(remaining, 0)
+
+	mode := rl.cfg.Get().Ratelimit.Headers
+	if mode == "" {
+		mode = RATELIMIT_HEADERS_LEGACY
+	}
+
+	if mode == RATELIMIT_HEADERS_LEGACY || mode == RATELIMIT_HEADERS_BOTH {
+		c.Header(RATELIMIT_HEADER, strconv.Itoa(limit))
+		c.Header(RATELIMIT_REMAINING, strconv.Itoa(remaining))
+		if ttl >= 0 {
+			c.Header(RATELIMIT_RESET, strconv.FormatInt(time.Now().Add(ttl).Unix(), 10))
+		}
+	}
+
+	if mode == RATELIMIT_HEADERS_IETF || mode == RATELIMIT_HEADERS_BOTH {
+		window := int64(math.Ceil(rl.cfg.Get().Ratelimit.Period.Seconds()))
+		c.Header(RATELIMIT_POLICY, fmt.Sprintf("%d;w=%d", limit, window))
+
+		value := fmt.Sprintf("limit=%d, remaining=%d", limit, remaining)
+		if ttl >= 0 {
+			value += fmt.Sprintf(", reset=%d", int64(math.Ceil(ttl.Seconds())))
+		}
+		c.Header(RATELIMIT, value)
+	}
+}
+
 func (rl *RateLimiterMiddleware) checkRateLimit(ctx context.Context, key string) (int, bool, error) {
 	var count int
 	err := rl.cache.Get(ctx, key, &count)
-	if err != nil && err != redis.Nil {
+	if err != nil && err != cache.ErrNotFound {
 		return 0, false, fmt.Errorf("error getting ratelimit count: %v", err)
 	}
 
-	if err == redis.Nil {
-		if err = rl.cache.Set(ctx, key, 1, rl.cfg.Ratelimit.Period); err != nil {
+	if err == cache.ErrNotFound {
+		if err = rl.cache.Set(ctx, key, 1, rl.cfg.Get().Ratelimit.Period); err != nil {
 			return 0, false, fmt.Errorf("error setting ratelimit count: %v", err)
 		}
 
 		count = 1
 	}
 
-	return count, count >= rl.cfg.Ratelimit.Limit, nil
+	return count, count >= rl.cfg.Get().Ratelimit.Limit, nil
 }
 
 func (rl *RateLimiterMiddleware) Close() error {
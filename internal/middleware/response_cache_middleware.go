@@ -0,0 +1,632 @@
+package middleware
+
+import (
+	"api-gateway-service-ms/config"
+	"api-gateway-service-ms/internal/pkg/cache"
+	"api-gateway-service-ms/internal/pkg/httpcache"
+	"api-gateway-service-ms/internal/pkg/logger"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	X_CACHE       = "X-Cache"
+	CACHE_HIT     = "HIT"
+	CACHE_MISS    = "MISS"
+	CACHE_STALE   = "STALE"
+	CACHE_TAG     = "Cache-Tag"
+	SURROGATE_KEY = "Surrogate-Key"
+
+	RESPONSE_CACHE_PREFIX         = "respcache:"
+	RESPONSE_CACHE_VARY_PREFIX    = "respcache_vary:"
+	RESPONSE_CACHE_TAG_PREFIX     = "respcache_tag:"
+	RESPONSE_CACHE_LOCK_PREFIX    = "respcache_lock:"
+	RESPONSE_CACHE_MAX_BODY_SIZE  = 1 << 20
+	RESPONSE_CACHE_REVALIDATE_TTL = 30 * time.Second
+	// Entries with validators are kept after expiry so they can be revalidated with a 304
+	RESPONSE_CACHE_VALIDATOR_RETENTION = time.Hour
+)
+
+// tagIndexScript adds a key to a tag index, keeping the longest TTL of its members
+var tagIndexScript = cache.NewScript(`
+local keys = {}
+local raw = redis.call("GET", KEYS[1])
+if raw then
+	keys = cjson.decode(raw)
+end
+local found = false
+for _, key in ipairs(keys) do
+	if key == ARGV[1] then
+		found = true
+	end
+end
+if not found then
+	table.insert(keys, ARGV[1])
+end
+local ttl = tonumber(ARGV[2])
+local current = redis.call("PTTL", KEYS[1])
+if current > ttl then
+	ttl = current
+end
+redis.call("SET", KEYS[1], cjson.encode(keys), "PX", ttl)
+return #keys`, func(store cache.ScriptStore, keys []string, args []interface{}) (interface{}, error) {
+	var members []string
+	if raw, ok := store.Get(keys[0]); ok {
+		if err := json.Unmarshal([]byte(raw), &members); err != nil {
+			return nil, err
+		}
+	}
+
+	member := args[0].(string)
+	if !slices.Contains(members, member) {
+		members = append(members, member)
+	}
+
+	ttl := time.Duration(args[1].(int64)) * time.Millisecond
+	ttl = max(ttl, store.TTL(keys[0]))
+
+	raw, err := json.Marshal(members)
+	if err != nil {
+		return nil, err
+	}
+	store.Set(keys[0], string(raw), ttl)
+
+	return int64(len(members)), nil
+})
+
+// revalidationKey marks the internal requests that refresh stale entries in the background,
+// carrying the user of the request that found the entry stale
+type revalidationKey struct{}
+
+// cacheableStatusCodes are the status codes stored by the response cache
+var cacheableStatusCodes = []int{
+	http.StatusOK,
+	http.StatusNonAuthoritativeInfo,
+	http.StatusMultipleChoices,
+	http.StatusMovedPermanently,
+	http.StatusNotFound,
+	http.StatusGone,
+}
+
+// bufferedResponseWriter holds the status, headers and body written by the handlers
+// so the response cache can decide what to send once the upstream has answered.
+// Responses larger than maxSize or flushed while streaming are passed through to the
+// underlying writer instead, and never stored.
+type bufferedResponseWriter struct {
+	gin.ResponseWriter
+	header      http.Header
+	body        *bytes.Buffer
+	status      int
+	maxSize     int64
+	passthrough bool
+}
+
+func newBufferedResponseWriter(w gin.ResponseWriter, maxSize int64) *bufferedResponseWriter {
+	return &bufferedResponseWriter{
+		ResponseWriter: w,
+		header:         w.Header().Clone(),
+		body:           bytes.NewBufferString(""),
+		maxSize:        maxSize,
+	}
+}
+
+func (w *bufferedResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *bufferedResponseWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+}
+
+func (w *bufferedResponseWriter) WriteHeaderNow() {
+	w.WriteHeader(http.StatusOK)
+}
+
+func (w *bufferedResponseWriter) Write(b []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	if !w.passthrough && int64(w.body.Len()+len(b)) > w.maxSize {
+		w.passThrough()
+	}
+	if w.passthrough {
+		return w.ResponseWriter.Write(b)
+	}
+
+	return w.body.Write(b)
+}
+
+func (w *bufferedResponseWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *bufferedResponseWriter) Status() int {
+	if w.status == 0 {
+		return http.StatusOK
+	}
+
+	return w.status
+}
+
+func (w *bufferedResponseWriter) Size() int {
+	if w.passthrough {
+		return w.ResponseWriter.Size()
+	}
+
+	return w.body.Len()
+}
+
+func (w *bufferedResponseWriter) Written() bool {
+	return w.status != 0
+}
+
+// Flush passes a streamed response through, e.g. server-sent events
+func (w *bufferedResponseWriter) Flush() {
+	w.passThrough()
+	w.ResponseWriter.Flush()
+}
+
+// passThrough sends what was buffered, then writes straight to the underlying writer
+func (w *bufferedResponseWriter) passThrough() {
+	if w.passthrough {
+		return
+	}
+
+	w.passthrough = true
+	w.header.Set(X_CACHE, CACHE_MISS)
+	w.flush()
+	w.body.Reset()
+}
+
+// flush sends the buffered response to the underlying writer
+func (w *bufferedResponseWriter) flush() {
+	header := w.ResponseWriter.Header()
+	for k := range header {
+		delete(header, k)
+	}
+	for k, v := range w.header {
+		header[k] = v
+	}
+
+	w.ResponseWriter.WriteHeader(w.Status())
+	w.ResponseWriter.Write(w.body.Bytes())
+}
+
+type ResponseCacheMiddleware struct {
+	cache   cache.Storage
+	logger  *logger.Logger
+	cfg     *config.Manager
+	handler http.Handler
+}
+
+func NewResponseCacheMiddleware(cache cache.Storage, logger *logger.Logger, cfg *config.Manager) *ResponseCacheMiddleware {
+	return &ResponseCacheMiddleware{
+		cache:  cache,
+		logger: logger,
+		cfg:    cfg,
+	}
+}
+
+// SetHandler sets the proxy handler used to refresh stale entries in the background.
+// Refreshes only go through the response cache and the proxy, so they aren't rate
+// limited, counted, logged or captured like client requests.
+func (rc *ResponseCacheMiddleware) SetHandler(handler gin.HandlerFunc) {
+	engine := gin.New()
+	engine.NoRoute(func(c *gin.Context) {
+		if userID, _ := c.Request.Context().Value(revalidationKey{}).(string); userID != "" {
+			c.Set("user_id", userID)
+		}
+	}, rc.HandleResponseCache(), handler)
+
+	rc.handler = engine
+}
+
+// HandleResponseCache serves GET responses from the storage following the HTTP caching rules
+func (rc *ResponseCacheMiddleware) HandleResponseCache() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !rc.cfg.Get().ResponseCache.Enabled || c.Request.Method != http.MethodGet {
+			c.Next()
+			return
+		}
+
+		// Only proxied requests are cached, the gateway's own routes such as /metrics
+		// always answer with their current state
+		if c.FullPath() != "" {
+			c.Next()
+			return
+		}
+
+		route := rc.routeConfig(c.Request.URL.Path)
+		if route != nil && route.Disabled {
+			c.Next()
+			return
+		}
+
+		// Private responses are only stored per user verified by the identity middleware
+		if route != nil && route.PerUser && c.GetString("user_id") == "" {
+			c.Next()
+			return
+		}
+
+		requestDirectives := httpcache.ParseCacheControl(c.Request.Header)
+		if requestDirectives.Has("no-store") {
+			c.Header(X_CACHE, CACHE_MISS)
+			c.Next()
+			return
+		}
+
+		// Storing the response must outlive a disconnected client
+		ctx := context.WithoutCancel(c.Request.Context())
+		now := time.Now()
+		primaryKey := httpcache.PrimaryKey(c.Request, rc.keyOptions(c, route))
+		entry := rc.lookup(ctx, primaryKey, c.Request.Header)
+
+		_, revalidating := c.Request.Context().Value(revalidationKey{}).(string)
+		if entry != nil && !revalidating && !requestDirectives.Has("no-cache") {
+			if entry.Fresh(now) {
+				rc.serve(c, entry, CACHE_HIT)
+				return
+			}
+
+			if entry.UsableWhileRevalidating(now) {
+				rc.serve(c, entry, CACHE_STALE)
+				rc.revalidateInBackground(c, primaryKey)
+				return
+			}
+		}
+
+		// Ask the upstream to revalidate our copy unless the client sent its own validators
+		conditional := false
+		if entry != nil && entry.HasValidators() &&
+			c.GetHeader("If-None-Match") == "" && c.GetHeader("If-Modified-Since") == "" {
+			if etag := entry.ETag(); etag != "" {
+				c.Request.Header.Set("If-None-Match", etag)
+			}
+			if lastModified := entry.LastModified(); lastModified != "" {
+				c.Request.Header.Set("If-Modified-Since", lastModified)
+			}
+			conditional = true
+		}
+
+		writer := newBufferedResponseWriter(c.Writer, rc.maxBodySize())
+		c.Writer = writer
+		c.Next()
+		c.Writer = writer.ResponseWriter
+
+		if conditional {
+			c.Request.Header.Del("If-None-Match")
+			c.Request.Header.Del("If-Modified-Since")
+		}
+
+		status := writer.Status()
+		switch {
+		case writer.passthrough:
+			// Already sent to the client, too large or streamed to be stored
+		case entry != nil && conditional && status == http.StatusNotModified:
+			// Our copy is still valid, refresh its metadata with the 304 headers
+			for k, v := range writer.header {
+				if k != "Content-Length" {
+					entry.Header[k] = v
+				}
+			}
+			rc.store(ctx, c, route, primaryKey, entry.StatusCode, entry.Header, entry.Body)
+			rc.serve(c, entry, CACHE_HIT)
+		case entry != nil && status >= http.StatusInternalServerError && entry.UsableOnError(time.Now()):
+			rc.logger.Warnf("Serving stale response for %s after upstream status %d", primaryKey, status)
+			rc.serve(c, entry, CACHE_STALE)
+		default:
+			writer.header.Set(X_CACHE, CACHE_MISS)
+			writer.flush()
+			rc.store(ctx, c, route, primaryKey, status, writer.header, writer.body.Bytes())
+		}
+	}
+}
+
+// PurgeKey removes the entries stored for a primary cache key and all its variants
+func (rc *ResponseCacheMiddleware) PurgeKey(ctx context.Context, key string) (int, error) {
+	return rc.purgePatterns(ctx,
+		escapeGlob(key),
+		escapeGlob(key)+"|*",
+	)
+}
+
+// PurgePath removes the entries stored for a path, whatever the query string and variant
+func (rc *ResponseCacheMiddleware) PurgePath(ctx context.Context, path string) (int, error) {
+	base := escapeGlob(http.MethodGet + " " + path)
+	return rc.purgePatterns(ctx, base, base+"?*", base+"|*")
+}
+
+// PurgeTag removes every entry stored with the tag
+func (rc *ResponseCacheMiddleware) PurgeTag(ctx context.Context, tag string) (int, error) {
+	tagKey := RESPONSE_CACHE_TAG_PREFIX + tag
+
+	var keys []string
+	if err := rc.cache.Get(ctx, tagKey, &keys); err != nil {
+		if err == cache.ErrNotFound {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	for _, key := range keys {
+		if err := rc.cache.Delete(ctx, key); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(keys), rc.cache.Delete(ctx, tagKey)
+}
+
+func (rc *ResponseCacheMiddleware) purgePatterns(ctx context.Context, patterns ...string) (int, error) {
+	purged := 0
+	for _, pattern := range patterns {
+		for _, prefix := range []string{RESPONSE_CACHE_PREFIX, RESPONSE_CACHE_VARY_PREFIX} {
+			keys, err := rc.cache.Scan(ctx, prefix+pattern)
+			if err != nil {
+				return purged, err
+			}
+
+			for _, key := range keys {
+				if err := rc.cache.Delete(ctx, key); err != nil {
+					return purged, err
+				}
+
+				if prefix == RESPONSE_CACHE_PREFIX {
+					purged++
+				}
+			}
+		}
+	}
+
+	return purged, nil
+}
+
+// lookup returns the entry matching the request variant, or nil
+func (rc *ResponseCacheMiddleware) lookup(ctx context.Context, primaryKey string, header http.Header) *httpcache.Entry {
+	var vary []string
+	if err := rc.cache.Get(ctx, RESPONSE_CACHE_VARY_PREFIX+primaryKey, &vary); err != nil && err != cache.ErrNotFound {
+		rc.logger.Errorf("Error reading response cache vary index: %v", err)
+		return nil
+	}
+
+	var entry httpcache.Entry
+	key := RESPONSE_CACHE_PREFIX + httpcache.VariantKey(primaryKey, vary, header)
+	if err := rc.cache.Get(ctx, key, &entry); err != nil {
+		if err != cache.ErrNotFound {
+			rc.logger.Errorf("Error reading response cache entry: %v", err)
+		}
+		return nil
+	}
+
+	return &entry
+}
+
+// store saves the response if the upstream and route allow it
+func (rc *ResponseCacheMiddleware) store(
+	ctx context.Context,
+	c *gin.Context,
+	route *config.ResponseCacheRouteConfig,
+	primaryKey string,
+	status int,
+	header http.Header,
+	body []byte,
+) {
+	if !slices.Contains(cacheableStatusCodes, status) || int64(len(body)) > rc.maxBodySize() {
+		return
+	}
+
+	directives := httpcache.ParseCacheControl(header)
+	if directives.Has("no-store") {
+		return
+	}
+
+	perUser := route != nil && route.PerUser
+	if !perUser && (directives.Has("private") || header.Get("Set-Cookie") != "") {
+		return
+	}
+
+	// Shared caches must not store answers to authenticated requests unless allowed explicitly
+	if !perUser && c.GetHeader("Authorization") != "" &&
+		!directives.Has("public") && !directives.Has("s-maxage") {
+		return
+	}
+
+	vary, ok := httpcache.VaryHeaders(header)
+	if !ok {
+		return
+	}
+
+	now := time.Now()
+	freshness := httpcache.Freshness(header, now)
+	if route != nil && route.TTL > 0 {
+		freshness = route.TTL
+	}
+
+	entry := httpcache.Entry{
+		StatusCode:           status,
+		Header:               header.Clone(),
+		Body:                 body,
+		StoredAt:             now,
+		FreshUntil:           now.Add(freshness),
+		StaleWhileRevalidate: rc.staleDuration(directives, "stale-while-revalidate", route, freshness),
+		StaleIfError:         rc.staleDuration(directives, "stale-if-error", route, freshness),
+		Tags:                 rc.tags(header),
+	}
+	entry.Header.Del(X_CACHE)
+	entry.Header.Del(X_REQUEST_ID)
+
+	// Without a freshness lifetime the response can't be served from the cache, and
+	// without validators it can't be revalidated either
+	if freshness <= 0 && !entry.HasValidators() {
+		return
+	}
+
+	retention := max(entry.StaleWhileRevalidate, entry.StaleIfError)
+	if entry.HasValidators() {
+		retention = max(retention, RESPONSE_CACHE_VALIDATOR_RETENTION)
+	}
+
+	ttl := freshness + retention
+	if ttl <= 0 {
+		return
+	}
+
+	key := RESPONSE_CACHE_PREFIX + httpcache.VariantKey(primaryKey, vary, c.Request.Header)
+	if err := rc.cache.Set(ctx, key, entry, ttl); err != nil {
+		rc.logger.Errorf("Failed to store response cache entry %s: %v", key, err)
+		return
+	}
+
+	if len(vary) > 0 {
+		varyIndex, _ := json.Marshal(vary)
+		if err := rc.cache.Set(ctx, RESPONSE_CACHE_VARY_PREFIX+primaryKey, varyIndex, ttl); err != nil {
+			rc.logger.Errorf("Failed to store response cache vary index %s: %v", primaryKey, err)
+		}
+	}
+
+	for _, tag := range entry.Tags {
+		_, err := rc.cache.Eval(ctx, tagIndexScript, []string{RESPONSE_CACHE_TAG_PREFIX + tag}, key, ttl.Milliseconds())
+		if err != nil {
+			rc.logger.Errorf("Failed to index response cache entry %s with tag %s: %v", key, tag, err)
+		}
+	}
+}
+
+// serve writes the stored response, answering 304 when the client already has it
+func (rc *ResponseCacheMiddleware) serve(c *gin.Context, entry *httpcache.Entry, status string) {
+	header := c.Writer.Header()
+	for k, v := range entry.Header {
+		if _, exists := header[k]; !exists {
+			header[k] = v
+		}
+	}
+
+	c.Header(X_CACHE, status)
+	c.Header("Age", strconv.FormatInt(entry.Age(time.Now()), 10))
+
+	if entry.MatchesConditional(c.Request) {
+		header.Del("Content-Length")
+		c.Status(http.StatusNotModified)
+		c.Writer.WriteHeaderNow()
+	} else {
+		c.Data(entry.StatusCode, entry.Header.Get("Content-Type"), entry.Body)
+	}
+
+	c.Abort()
+}
+
+// revalidateInBackground replays the request through the proxy to refresh a stale entry.
+// A short lock keeps concurrent requests from triggering duplicate refreshes.
+func (rc *ResponseCacheMiddleware) revalidateInBackground(c *gin.Context, primaryKey string) {
+	if rc.handler == nil {
+		return
+	}
+
+	ctx := context.Background()
+	lockKey := RESPONSE_CACHE_LOCK_PREFIX + primaryKey
+	acquired, err := rc.cache.SetNX(ctx, lockKey, 1, RESPONSE_CACHE_REVALIDATE_TTL)
+	if err != nil || !acquired {
+		return
+	}
+
+	// The refresh is bounded by the lock, and the proxy needs a cancelable context
+	reqCtx, cancel := context.WithTimeout(context.WithValue(ctx, revalidationKey{}, c.GetString("user_id")), RESPONSE_CACHE_REVALIDATE_TTL)
+	req := c.Request.Clone(reqCtx)
+	req.Body = http.NoBody
+	req.Header.Del("If-None-Match")
+	req.Header.Del("If-Modified-Since")
+
+	go func() {
+		defer cancel()
+		defer rc.cache.Delete(ctx, lockKey)
+
+		recorder := httptest.NewRecorder()
+		rc.handler.ServeHTTP(recorder, req)
+		rc.logger.Debugf("Revalidated response cache entry %s with status %d", primaryKey, recorder.Code)
+	}()
+}
+
+// routeConfig returns the settings of the longest configured path prefix matching the path
+func (rc *ResponseCacheMiddleware) routeConfig(path string) *config.ResponseCacheRouteConfig {
+	return httpcache.MatchRoute(rc.cfg.Get().ResponseCache.Routes, path)
+}
+
+// keyOptions returns the cache key composition of the route
+func (rc *ResponseCacheMiddleware) keyOptions(c *gin.Context, route *config.ResponseCacheRouteConfig) httpcache.KeyOptions {
+	return httpcache.RouteKeyOptions(route, c.Request, c.GetString("user_id"))
+}
+
+// staleDuration returns how long the response may be served stale. The configured
+// windows only extend an explicit freshness lifetime, a response without one is never
+// served stale unless the upstream allows it.
+func (rc *ResponseCacheMiddleware) staleDuration(
+	directives httpcache.Directives,
+	name string,
+	route *config.ResponseCacheRouteConfig,
+	freshness time.Duration,
+) time.Duration {
+	if d, ok := directives.Duration(name); ok {
+		return d
+	}
+	if freshness <= 0 {
+		return 0
+	}
+
+	if name == "stale-while-revalidate" {
+		if route != nil && route.StaleWhileRevalidate > 0 {
+			return route.StaleWhileRevalidate
+		}
+		return rc.cfg.Get().ResponseCache.StaleWhileRevalidate
+	}
+
+	if route != nil && route.StaleIfError > 0 {
+		return route.StaleIfError
+	}
+	return rc.cfg.Get().ResponseCache.StaleIfError
+}
+
+// tags returns the purge tags sent by the upstream in Cache-Tag or Surrogate-Key
+func (rc *ResponseCacheMiddleware) tags(header http.Header) []string {
+	tagHeader := rc.cfg.Get().ResponseCache.TagHeader
+	if tagHeader == "" {
+		tagHeader = CACHE_TAG
+	}
+
+	var tags []string
+	for _, value := range header.Values(tagHeader) {
+		for _, tag := range strings.Split(value, ",") {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				tags = append(tags, tag)
+			}
+		}
+	}
+
+	for _, value := range header.Values(SURROGATE_KEY) {
+		tags = append(tags, strings.Fields(value)...)
+	}
+
+	return tags
+}
+
+func (rc *ResponseCacheMiddleware) maxBodySize() int64 {
+	if rc.cfg.Get().ResponseCache.MaxBodySize > 0 {
+		return rc.cfg.Get().ResponseCache.MaxBodySize
+	}
+
+	return RESPONSE_CACHE_MAX_BODY_SIZE
+}
+
+// escapeGlob escapes the glob metacharacters used by Scan patterns
+func escapeGlob(s string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`, `[`, `\[`, `]`, `\]`)
+	return replacer.Replace(s)
+}
@@ -0,0 +1,170 @@
+package middleware
+
+import (
+	"api-gateway-service-ms/internal/pkg/cache"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+const RESPONSE_CACHE_TEST_CONFIG = `response_cache:
+  enabled: true
+  max_body_size: 16
+  stale_while_revalidate: 30s
+  stale_if_error: 5m
+  routes:
+    - path: /private
+      per_user: true
+`
+
+func newResponseCacheRouter(t *testing.T, hits *atomic.Int64, handler gin.HandlerFunc) *gin.Engine {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	log := newTestLogger()
+	rc := NewResponseCacheMiddleware(cache.NewMemoryCache(log), log, newTestConfig(t, RESPONSE_CACHE_TEST_CONFIG))
+
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		// Stands in for the identity middleware
+		if userID := c.GetHeader("X-Test-User"); userID != "" {
+			c.Set("user_id", userID)
+		}
+	})
+	router.Use(rc.HandleResponseCache())
+	router.GET("/metrics", func(c *gin.Context) {
+		hits.Add(1)
+		c.Header("Cache-Control", "max-age=60")
+		c.String(http.StatusOK, "requests %d", hits.Load())
+	})
+	router.NoRoute(func(c *gin.Context) {
+		hits.Add(1)
+		c.Header("Cache-Control", "max-age=60")
+		handler(c)
+	})
+
+	return router
+}
+
+func get(router http.Handler, path, userID string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	if userID != "" {
+		req.Header.Set("X-Test-User", userID)
+	}
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, req)
+	return recorder
+}
+
+func TestResponseCacheStoresSmallResponses(t *testing.T) {
+	var hits atomic.Int64
+	router := newResponseCacheRouter(t, &hits, func(c *gin.Context) {
+		c.String(http.StatusOK, "small")
+	})
+
+	get(router, "/public", "")
+	recorder := get(router, "/public", "")
+	if hits.Load() != 1 || recorder.Header().Get(X_CACHE) != CACHE_HIT || recorder.Body.String() != "small" {
+		t.Fatalf("got %d hits, X-Cache %q and body %q, want the stored response", hits.Load(), recorder.Header().Get(X_CACHE), recorder.Body.String())
+	}
+}
+
+func TestResponseCachePassesLargeResponsesThrough(t *testing.T) {
+	var hits atomic.Int64
+	body := strings.Repeat("x", 64)
+	router := newResponseCacheRouter(t, &hits, func(c *gin.Context) {
+		c.Status(http.StatusOK)
+		for i := 0; i < len(body); i += 8 {
+			c.Writer.WriteString(body[i : i+8])
+		}
+	})
+
+	for range 2 {
+		recorder := get(router, "/large", "")
+		if recorder.Body.String() != body || recorder.Header().Get(X_CACHE) != CACHE_MISS {
+			t.Fatalf("got X-Cache %q and body %q, want the whole body uncached", recorder.Header().Get(X_CACHE), recorder.Body.String())
+		}
+	}
+	if hits.Load() != 2 {
+		t.Fatalf("backend hit %d times, want 2", hits.Load())
+	}
+}
+
+func TestResponseCacheForwardsFlush(t *testing.T) {
+	var hits atomic.Int64
+	recorder := httptest.NewRecorder()
+	router := newResponseCacheRouter(t, &hits, func(c *gin.Context) {
+		c.Header("Content-Type", "text/event-stream")
+		c.Writer.WriteString("data: 1\n\n")
+		c.Writer.Flush()
+
+		if hits.Load() == 1 && (!recorder.Flushed || recorder.Body.String() != "data: 1\n\n") {
+			t.Errorf("event not sent on flush, got %q", recorder.Body.String())
+		}
+		c.Writer.WriteString("data: 2\n\n")
+	})
+
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/events", nil))
+	if recorder.Body.String() != "data: 1\n\ndata: 2\n\n" {
+		t.Fatalf("got body %q, want both events", recorder.Body.String())
+	}
+
+	get(router, "/events", "")
+	if hits.Load() != 2 {
+		t.Fatalf("backend hit %d times, want the stream never stored", hits.Load())
+	}
+}
+
+func TestResponseCachePerUserRequiresIdentity(t *testing.T) {
+	var hits atomic.Int64
+	router := newResponseCacheRouter(t, &hits, func(c *gin.Context) {
+		c.String(http.StatusOK, "for "+c.GetString("user_id"))
+	})
+
+	get(router, "/private", "")
+	if recorder := get(router, "/private", ""); recorder.Header().Get(X_CACHE) == CACHE_HIT {
+		t.Fatal("response stored without a verified identity")
+	}
+
+	get(router, "/private", "alice")
+	if recorder := get(router, "/private", "bob"); recorder.Body.String() != "for bob" {
+		t.Fatalf("got %q, want bob's own response", recorder.Body.String())
+	}
+	if recorder := get(router, "/private", "alice"); recorder.Header().Get(X_CACHE) != CACHE_HIT || recorder.Body.String() != "for alice" {
+		t.Fatalf("got X-Cache %q and body %q, want alice's stored response", recorder.Header().Get(X_CACHE), recorder.Body.String())
+	}
+	if hits.Load() != 4 {
+		t.Fatalf("backend hit %d times, want 4", hits.Load())
+	}
+}
+
+func TestResponseCacheSkipsResponsesWithoutFreshness(t *testing.T) {
+	var hits atomic.Int64
+	router := newResponseCacheRouter(t, &hits, func(c *gin.Context) {
+		c.Writer.Header().Del("Cache-Control")
+		c.String(http.StatusOK, "small")
+	})
+
+	get(router, "/public", "")
+	if recorder := get(router, "/public", ""); recorder.Header().Get(X_CACHE) != CACHE_MISS {
+		t.Fatalf("got X-Cache %q, want a response without freshness never served from the cache", recorder.Header().Get(X_CACHE))
+	}
+	if hits.Load() != 2 {
+		t.Fatalf("backend hit %d times, want 2", hits.Load())
+	}
+}
+
+func TestResponseCacheSkipsGatewayRoutes(t *testing.T) {
+	var hits atomic.Int64
+	router := newResponseCacheRouter(t, &hits, func(c *gin.Context) {})
+
+	get(router, "/metrics", "")
+	recorder := get(router, "/metrics", "")
+	if recorder.Header().Get(X_CACHE) != "" || recorder.Body.String() != "requests 2" {
+		t.Fatalf("got X-Cache %q and body %q, want the current metrics", recorder.Header().Get(X_CACHE), recorder.Body.String())
+	}
+}
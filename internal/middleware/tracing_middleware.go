@@ -0,0 +1,62 @@
+package middleware
+
+import (
+	"api-gateway-service-ms/config"
+	"api-gateway-service-ms/internal/pkg/logger"
+	"api-gateway-service-ms/internal/pkg/tracing"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type TracingMiddleware struct {
+	cfg *config.Manager
+}
+
+func NewTracingMiddleware(cfg *config.Manager) *TracingMiddleware {
+	return &TracingMiddleware{cfg: cfg}
+}
+
+// HandleTracing continues the trace found in the request headers, or starts a new one,
+// with a server span per request. Later middlewares and the proxy start child spans from
+// the request context.
+func (tm *TracingMiddleware) HandleTracing() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := tracing.Extract(c.Request.Context(), c.Request.Header)
+
+		route, upstream := routeTemplate(c, tm.cfg.Get())
+		ctx, span := tracing.Start(ctx, c.Request.Method+" "+route,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPRequestMethodKey.String(c.Request.Method),
+				semconv.HTTPRoute(route),
+				semconv.URLPath(c.Request.URL.Path),
+				semconv.ClientAddress(c.ClientIP()),
+				semconv.UserAgentOriginal(c.Request.UserAgent()),
+			),
+		)
+		defer span.End()
+
+		if upstream != "" {
+			span.SetAttributes(attribute.String("gateway.upstream", upstream))
+		}
+
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+
+		status := c.Writer.Status()
+		span.SetAttributes(semconv.HTTPResponseStatusCode(status))
+		if requestID := c.GetString(logger.FieldRequestID); requestID != "" {
+			span.SetAttributes(attribute.String("gateway.request_id", requestID))
+		}
+
+		// Client errors are not failures of the gateway
+		if status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(status))
+		}
+	}
+}
@@ -0,0 +1,224 @@
+package accesslog
+
+import (
+	"api-gateway-service-ms/config"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+	"text/template"
+	"time"
+)
+
+const (
+	FORMAT_JSON     = "json"
+	FORMAT_COMMON   = "common"
+	FORMAT_COMBINED = "combined"
+	FORMAT_TEMPLATE = "template"
+
+	SINK_STDOUT = "stdout"
+	SINK_FILE   = "file"
+	SINK_SYSLOG = "syslog"
+
+	// CLF_TIME is the timestamp layout of the Common Log Format
+	CLF_TIME = "02/Jan/2006:15:04:05 -0700"
+)
+
+// Context keys set by the middlewares and the proxy for the access log entry
+const (
+	KeyUpstreamAddr       = "access_log.upstream_addr"
+	KeyUpstreamLatency    = "access_log.upstream_latency"
+	KeyRatelimitState     = "access_log.ratelimit_state"
+	KeyRatelimitRemaining = "access_log.ratelimit_remaining"
+)
+
+// Rate limit states of a request
+const (
+	RATELIMIT_ALLOWED = "allowed"
+	RATELIMIT_LIMITED = "limited"
+	RATELIMIT_ERROR   = "error"
+)
+
+// Entry describes a handled request. Durations are in milliseconds, unknown values are
+// left empty and omitted from JSON lines.
+type Entry struct {
+	Time               time.Time `json:"time"`
+	RemoteAddr         string    `json:"remote_addr"`
+	UserID             string    `json:"user_id,omitempty"`
+	Method             string    `json:"method"`
+	Path               string    `json:"path"`
+	Protocol           string    `json:"protocol"`
+	Status             int       `json:"status"`
+	BytesIn            int64     `json:"bytes_in"`
+	BytesOut           int64     `json:"bytes_out"`
+	Duration           float64   `json:"duration_ms"`
+	Referer            string    `json:"referer,omitempty"`
+	UserAgent          string    `json:"user_agent,omitempty"`
+	RequestID          string    `json:"request_id,omitempty"`
+	TraceID            string    `json:"trace_id,omitempty"`
+	Route              string    `json:"route,omitempty"`
+	Upstream           string    `json:"upstream,omitempty"`
+	UpstreamAddr       string    `json:"upstream_addr,omitempty"`
+	UpstreamLatency    *float64  `json:"upstream_latency_ms,omitempty"`
+	TLSVersion         string    `json:"tls_version,omitempty"`
+	RatelimitState     string    `json:"ratelimit_state,omitempty"`
+	RatelimitRemaining *int      `json:"ratelimit_remaining,omitempty"`
+	CacheStatus        string    `json:"cache_status,omitempty"`
+}
+
+// Logger formats entries and writes each line to every sink
+type Logger struct {
+	format   string
+	template *template.Template
+	sinks    []io.WriteCloser
+	mu       sync.Mutex
+}
+
+// New opens the configured sinks
+func New(cfg config.AccessLogConfig) (*Logger, error) {
+	l := &Logger{format: cfg.Format}
+	if l.format == "" {
+		l.format = FORMAT_JSON
+	}
+
+	if l.format == FORMAT_TEMPLATE {
+		tmpl, err := template.New("access_log").Parse(cfg.Template)
+		if err != nil {
+			return nil, fmt.Errorf("invalid access log template: %w", err)
+		}
+		l.template = tmpl
+	}
+
+	sinks := cfg.Sinks
+	if len(sinks) == 0 {
+		sinks = []config.AccessLogSinkConfig{{Type: SINK_STDOUT}}
+	}
+
+	for _, sinkConfig := range sinks {
+		sink, err := newSink(sinkConfig)
+		if err != nil {
+			l.Close()
+			return nil, err
+		}
+		l.sinks = append(l.sinks, sink)
+	}
+
+	return l, nil
+}
+
+func newSink(cfg config.AccessLogSinkConfig) (io.WriteCloser, error) {
+	switch cfg.Type {
+	case "", SINK_STDOUT:
+		return nopCloser{os.Stdout}, nil
+	case SINK_FILE:
+		return NewRotatingFile(cfg.Path, cfg.MaxSize, cfg.MaxBackups)
+	case SINK_SYSLOG:
+		return newSyslog(cfg)
+	default:
+		return nil, fmt.Errorf("unsupported access log sink: %s", cfg.Type)
+	}
+}
+
+// Log writes the entry to every sink. A failing sink doesn't keep the line from the others.
+func (l *Logger) Log(entry *Entry) error {
+	line, err := l.Format(entry)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var errs []error
+	for _, sink := range l.sinks {
+		if _, err := sink.Write(line); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Format returns the entry as a line in the configured format, ending with a newline
+func (l *Logger) Format(entry *Entry) ([]byte, error) {
+	var buf bytes.Buffer
+	switch l.format {
+	case FORMAT_COMMON, FORMAT_COMBINED:
+		writeCLF(&buf, entry, l.format == FORMAT_COMBINED)
+	case FORMAT_TEMPLATE:
+		if err := l.template.Execute(&buf, entry); err != nil {
+			return nil, fmt.Errorf("failed to execute access log template: %w", err)
+		}
+	default:
+		if err := json.NewEncoder(&buf).Encode(entry); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+
+	buf.WriteByte('\n')
+	return buf.Bytes(), nil
+}
+
+// writeCLF writes the Common Log Format, followed by the referer and user agent in the
+// Combined Log Format:
+// remote - user [time] "METHOD path PROTO" status bytes "referer" "user-agent"
+func writeCLF(buf *bytes.Buffer, entry *Entry, combined bool) {
+	buf.WriteString(orDash(entry.RemoteAddr))
+	buf.WriteString(" - ")
+	buf.WriteString(orDash(entry.UserID))
+	buf.WriteString(" [")
+	buf.WriteString(entry.Time.Format(CLF_TIME))
+	buf.WriteString("] ")
+	buf.WriteString(strconv.Quote(entry.Method + " " + entry.Path + " " + entry.Protocol))
+	buf.WriteByte(' ')
+	buf.WriteString(strconv.Itoa(entry.Status))
+	buf.WriteByte(' ')
+	if entry.BytesOut > 0 {
+		buf.WriteString(strconv.FormatInt(entry.BytesOut, 10))
+	} else {
+		buf.WriteByte('-')
+	}
+
+	if combined {
+		buf.WriteByte(' ')
+		buf.WriteString(strconv.Quote(orDash(entry.Referer)))
+		buf.WriteByte(' ')
+		buf.WriteString(strconv.Quote(orDash(entry.UserAgent)))
+	}
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+
+	return s
+}
+
+// Close closes every sink
+func (l *Logger) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var errs []error
+	for _, sink := range l.sinks {
+		if err := sink.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
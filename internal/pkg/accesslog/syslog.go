@@ -0,0 +1,26 @@
+package accesslog
+
+import (
+	"api-gateway-service-ms/config"
+	"fmt"
+	"log/syslog"
+)
+
+const DEFAULT_SYSLOG_TAG = "api-gateway"
+
+// newSyslog connects to the syslog server, or the local syslog socket when no address
+// is configured. Lines are sent with the info severity of the local7 facility, like
+// the Nginx access log.
+func newSyslog(cfg config.AccessLogSinkConfig) (*syslog.Writer, error) {
+	tag := cfg.Tag
+	if tag == "" {
+		tag = DEFAULT_SYSLOG_TAG
+	}
+
+	writer, err := syslog.Dial(cfg.Network, cfg.Address, syslog.LOG_INFO|syslog.LOG_LOCAL7, tag)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to syslog: %w", err)
+	}
+
+	return writer, nil
+}
@@ -4,29 +4,121 @@ import (
 	"api-gateway-service-ms/config"
 	"api-gateway-service-ms/internal/pkg/logger"
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"fmt"
+	"os"
+	"sync"
 	"time"
 
 	"github.com/redis/go-redis/v9"
 )
 
+const (
+	MODE_STANDALONE = "standalone"
+	MODE_SENTINEL   = "sentinel"
+	MODE_CLUSTER    = "cluster"
+)
+
+// Cache is the Redis implementation of Storage, backed by a single node,
+// a Sentinel monitored master or a Redis Cluster
 type Cache struct {
 	logger      *logger.Logger
-	cacheClient *redis.Client
+	cacheClient redis.UniversalClient
 }
 
-func NewCacheClient(logger *logger.Logger, cfg *config.Config) *Cache {
-	redisClient := redis.NewClient(&redis.Options{
-		Addr:     fmt.Sprintf("%s:%s", cfg.Cache.Host, cfg.Cache.Port),
-		Password: cfg.Cache.Password,
-		DB:       cfg.Cache.DB,
-	})
+func NewCacheClient(logger *logger.Logger, cfg *config.Config) (*Cache, error) {
+	addrs := cfg.Cache.Addrs
+	if len(addrs) == 0 {
+		addrs = []string{fmt.Sprintf("%s:%s", cfg.Cache.Host, cfg.Cache.Port)}
+	}
+
+	opts := &redis.UniversalOptions{
+		Addrs:            addrs,
+		DB:               cfg.Cache.DB,
+		Username:         cfg.Cache.User,
+		Password:         cfg.Cache.Password,
+		MasterName:       cfg.Cache.MasterName,
+		SentinelUsername: cfg.Cache.SentinelUser,
+		SentinelPassword: cfg.Cache.SentinelPassword,
+		PoolSize:         cfg.Cache.PoolSize,
+		MinIdleConns:     cfg.Cache.MinIdleConns,
+		PoolTimeout:      cfg.Cache.PoolTimeout,
+		DialTimeout:      cfg.Cache.DialTimeout,
+		ReadTimeout:      cfg.Cache.ReadTimeout,
+		WriteTimeout:     cfg.Cache.WriteTimeout,
+	}
+
+	if cfg.Cache.TLS.Enable {
+		tlsConfig, err := newTLSConfig(cfg.Cache.TLS)
+		if err != nil {
+			return nil, err
+		}
+		opts.TLSConfig = tlsConfig
+	}
+
+	var redisClient redis.UniversalClient
+	switch cfg.Cache.Mode {
+	case "", MODE_STANDALONE:
+		redisClient = redis.NewClient(opts.Simple())
+	case MODE_SENTINEL:
+		if opts.MasterName == "" {
+			return nil, fmt.Errorf("cache master_name is required in sentinel mode")
+		}
+		redisClient = redis.NewFailoverClient(opts.Failover())
+	case MODE_CLUSTER:
+		redisClient = redis.NewClusterClient(opts.Cluster())
+	default:
+		return nil, fmt.Errorf("unsupported cache mode: %s", cfg.Cache.Mode)
+	}
 
 	return &Cache{
 		logger:      logger,
 		cacheClient: redisClient,
+	}, nil
+}
+
+func newTLSConfig(cfg config.CacheTLSConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         cfg.ServerName,
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+	}
+
+	if cfg.CAFile != "" {
+		caCert, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read cache CA file: %w", err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caCert) {
+			return nil, fmt.Errorf("failed to parse cache CA file: %s", cfg.CAFile)
+		}
+		tlsConfig.RootCAs = pool
 	}
+
+	if cfg.CertFile != "" || cfg.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load cache client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+// AddHook instruments every command sent to Redis, e.g. to observe latency
+func (c *Cache) AddHook(hook redis.Hook) {
+	c.cacheClient.AddHook(hook)
+}
+
+// HashTag wraps part of a key in braces so that every key sharing it lands on the
+// same Redis Cluster slot, which multi-key scripts require
+func HashTag(tag string) string {
+	return "{" + tag + "}"
 }
 
 func (c *Cache) Ping(ctx context.Context) error {
@@ -59,6 +151,66 @@ func (c *Cache) Set(ctx context.Context, key string, value interface{}, expirati
 	return nil
 }
 
+func (c *Cache) SetNX(ctx context.Context, key string, value interface{}, expiration time.Duration) (bool, error) {
+	return c.cacheClient.SetNX(ctx, key, value, expiration).Result()
+}
+
+func (c *Cache) Eval(ctx context.Context, script *Script, keys []string, args ...interface{}) (interface{}, error) {
+	return script.lua.Run(ctx, c.cacheClient, keys, args...).Result()
+}
+
+func (c *Cache) Scan(ctx context.Context, pattern string) ([]string, error) {
+	cluster, ok := c.cacheClient.(*redis.ClusterClient)
+	if !ok {
+		return scanKeys(ctx, c.cacheClient, pattern)
+	}
+
+	// In cluster mode every master only knows the keys of its own slots
+	var (
+		mu   sync.Mutex
+		keys []string
+	)
+	err := cluster.ForEachMaster(ctx, func(ctx context.Context, master *redis.Client) error {
+		masterKeys, err := scanKeys(ctx, master, pattern)
+		if err != nil {
+			return err
+		}
+
+		mu.Lock()
+		keys = append(keys, masterKeys...)
+		mu.Unlock()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+func scanKeys(ctx context.Context, client redis.Cmdable, pattern string) ([]string, error) {
+	var keys []string
+	iter := client.Scan(ctx, 0, pattern, 100).Iterator()
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+func (c *Cache) Exists(ctx context.Context, key string) (bool, error) {
+	n, err := c.cacheClient.Exists(ctx, key).Result()
+	if err != nil {
+		return false, err
+	}
+
+	return n > 0, nil
+}
+
 func (c *Cache) Delete(ctx context.Context, key string) error {
 	if err := c.cacheClient.Del(ctx, key).Err(); err != nil {
 		return err
@@ -0,0 +1,116 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrLockNotAcquired is returned when the lock is already held by someone else
+var ErrLockNotAcquired = errors.New("lock is held by another owner")
+
+var (
+	// releaseScript deletes the lock only if it is still owned by the caller
+	releaseScript = NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`, func(store ScriptStore, keys []string, args []interface{}) (interface{}, error) {
+		if value, ok := store.Get(keys[0]); ok && value == args[0] {
+			store.Delete(keys[0])
+			return int64(1), nil
+		}
+		return int64(0), nil
+	})
+
+	// refreshScript extends the lock TTL only if it is still owned by the caller
+	refreshScript = NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0`, func(store ScriptStore, keys []string, args []interface{}) (interface{}, error) {
+		if value, ok := store.Get(keys[0]); ok && value == args[0] {
+			store.Expire(keys[0], time.Duration(args[1].(int64))*time.Millisecond)
+			return int64(1), nil
+		}
+		return int64(0), nil
+	})
+)
+
+// Lock is a distributed lock identified by a random owner token
+type Lock struct {
+	store Storage
+	key   string
+	token string
+	ttl   time.Duration
+}
+
+// AcquireLock takes the lock with SET NX, failing with ErrLockNotAcquired if it is held
+func AcquireLock(ctx context.Context, store Storage, key string, ttl time.Duration) (*Lock, error) {
+	token := uuid.New().String()
+
+	ok, err := store.SetNX(ctx, key, token, ttl)
+	if err != nil {
+		return nil, err
+	}
+
+	if !ok {
+		return nil, ErrLockNotAcquired
+	}
+
+	return &Lock{
+		store: store,
+		key:   key,
+		token: token,
+		ttl:   ttl,
+	}, nil
+}
+
+// Refresh extends the lock TTL, failing with ErrLockNotAcquired if the lock was lost
+func (l *Lock) Refresh(ctx context.Context) error {
+	result, err := l.store.Eval(ctx, refreshScript, []string{l.key}, l.token, l.ttl.Milliseconds())
+	if err != nil {
+		return err
+	}
+
+	if n, _ := result.(int64); n == 0 {
+		return ErrLockNotAcquired
+	}
+
+	return nil
+}
+
+// Release deletes the lock if it is still owned by this holder
+func (l *Lock) Release(ctx context.Context) error {
+	_, err := l.store.Eval(ctx, releaseScript, []string{l.key}, l.token)
+	return err
+}
+
+// KeepAlive refreshes the lock periodically until the returned function is called
+func (l *Lock) KeepAlive(ctx context.Context, onLost func(error)) func() {
+	done := make(chan struct{})
+	interval := l.ttl / 3
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := l.Refresh(ctx); err != nil {
+					if onLost != nil {
+						onLost(err)
+					}
+					return
+				}
+			}
+		}
+	}()
+
+	return func() { close(done) }
+}
@@ -0,0 +1,359 @@
+package cache
+
+import (
+	"api-gateway-service-ms/internal/pkg/logger"
+	"context"
+	"encoding"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const MEMORY_JANITOR_INTERVAL = time.Minute
+
+var errNoLocalScript = errors.New("script has no in-memory implementation")
+
+type memoryEntry struct {
+	value     string
+	expiresAt time.Time
+}
+
+func (e memoryEntry) expired(now time.Time) bool {
+	return !e.expiresAt.IsZero() && !now.Before(e.expiresAt)
+}
+
+// MemoryCache is an in-process implementation of Storage with TTL expiry.
+// It is meant for local development and tests, as state is not shared between instances.
+type MemoryCache struct {
+	logger  *logger.Logger
+	mu      sync.Mutex
+	entries map[string]memoryEntry
+	done    chan struct{}
+	once    sync.Once
+}
+
+func NewMemoryCache(logger *logger.Logger) *MemoryCache {
+	m := &MemoryCache{
+		logger:  logger,
+		entries: make(map[string]memoryEntry),
+		done:    make(chan struct{}),
+	}
+
+	go m.janitor()
+
+	return m
+}
+
+func (m *MemoryCache) Ping(ctx context.Context) error {
+	return nil
+}
+
+func (m *MemoryCache) Get(ctx context.Context, key string, obj interface{}) error {
+	m.mu.Lock()
+	value, ok := m.get(key)
+	m.mu.Unlock()
+
+	if !ok {
+		return ErrNotFound
+	}
+
+	return json.Unmarshal([]byte(value), &obj)
+}
+
+func (m *MemoryCache) Set(ctx context.Context, key string, value interface{}, expiration time.Duration) error {
+	formatted, err := formatValue(value)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.set(key, formatted, expiration)
+	return nil
+}
+
+func (m *MemoryCache) SetNX(ctx context.Context, key string, value interface{}, expiration time.Duration) (bool, error) {
+	formatted, err := formatValue(value)
+	if err != nil {
+		return false, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.get(key); ok {
+		return false, nil
+	}
+
+	m.set(key, formatted, expiration)
+	return true, nil
+}
+
+func (m *MemoryCache) Delete(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.entries, key)
+	return nil
+}
+
+func (m *MemoryCache) Exists(ctx context.Context, key string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, ok := m.get(key)
+	return ok, nil
+}
+
+func (m *MemoryCache) Incr(ctx context.Context, key string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var n int64
+	entry, ok := m.entries[key]
+	if ok && !entry.expired(time.Now()) {
+		var err error
+		n, err = strconv.ParseInt(entry.value, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("value is not an integer or out of range")
+		}
+	} else {
+		entry = memoryEntry{}
+	}
+
+	n++
+	entry.value = strconv.FormatInt(n, 10)
+	m.entries[key] = entry
+
+	return n, nil
+}
+
+func (m *MemoryCache) TTL(ctx context.Context, key string) (time.Duration, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ttl := memoryScriptStore{m}.TTL(key)
+	if ttl < 0 {
+		return ttl, nil
+	}
+
+	return ttl.Round(time.Second), nil
+}
+
+func (m *MemoryCache) Eval(ctx context.Context, script *Script, keys []string, args ...interface{}) (interface{}, error) {
+	if script.local == nil {
+		return nil, errNoLocalScript
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return script.local(memoryScriptStore{m}, keys, args)
+}
+
+func (m *MemoryCache) Scan(ctx context.Context, pattern string) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var keys []string
+	now := time.Now()
+	for key, entry := range m.entries {
+		if entry.expired(now) {
+			continue
+		}
+
+		if matchGlob(pattern, key) {
+			keys = append(keys, key)
+		}
+	}
+
+	return keys, nil
+}
+
+func (m *MemoryCache) Close() error {
+	m.once.Do(func() { close(m.done) })
+	return nil
+}
+
+// get must be called with the lock held
+func (m *MemoryCache) get(key string) (string, bool) {
+	entry, ok := m.entries[key]
+	if !ok {
+		return "", false
+	}
+
+	if entry.expired(time.Now()) {
+		delete(m.entries, key)
+		return "", false
+	}
+
+	return entry.value, true
+}
+
+// set must be called with the lock held
+func (m *MemoryCache) set(key, value string, expiration time.Duration) {
+	entry := memoryEntry{value: value}
+	if expiration > 0 {
+		entry.expiresAt = time.Now().Add(expiration)
+	}
+
+	m.entries[key] = entry
+}
+
+// janitor periodically evicts expired entries so unused keys don't leak memory
+func (m *MemoryCache) janitor() {
+	ticker := time.NewTicker(MEMORY_JANITOR_INTERVAL)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.done:
+			return
+		case now := <-ticker.C:
+			m.mu.Lock()
+			for key, entry := range m.entries {
+				if entry.expired(now) {
+					delete(m.entries, key)
+				}
+			}
+			m.mu.Unlock()
+		}
+	}
+}
+
+// memoryScriptStore exposes the unlocked accessors to local scripts
+type memoryScriptStore struct {
+	m *MemoryCache
+}
+
+func (s memoryScriptStore) Get(key string) (string, bool) {
+	return s.m.get(key)
+}
+
+func (s memoryScriptStore) Set(key, value string, expiration time.Duration) {
+	s.m.set(key, value, expiration)
+}
+
+func (s memoryScriptStore) Delete(key string) bool {
+	if _, ok := s.m.get(key); !ok {
+		return false
+	}
+
+	delete(s.m.entries, key)
+	return true
+}
+
+func (s memoryScriptStore) Expire(key string, expiration time.Duration) bool {
+	entry, ok := s.m.entries[key]
+	if !ok || entry.expired(time.Now()) {
+		return false
+	}
+
+	entry.expiresAt = time.Now().Add(expiration)
+	s.m.entries[key] = entry
+	return true
+}
+
+func (s memoryScriptStore) TTL(key string) time.Duration {
+	entry, ok := s.m.entries[key]
+	now := time.Now()
+	if !ok || entry.expired(now) {
+		return -2
+	}
+
+	if entry.expiresAt.IsZero() {
+		return -1
+	}
+
+	return entry.expiresAt.Sub(now)
+}
+
+// formatValue converts a value the same way the Redis client writes command arguments
+func formatValue(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case []byte:
+		return string(v), nil
+	case int:
+		return strconv.Itoa(v), nil
+	case int32:
+		return strconv.FormatInt(int64(v), 10), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case uint64:
+		return strconv.FormatUint(v, 10), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case bool:
+		if v {
+			return "1", nil
+		}
+		return "0", nil
+	case time.Duration:
+		return strconv.FormatInt(int64(v), 10), nil
+	case encoding.BinaryMarshaler:
+		b, err := v.MarshalBinary()
+		if err != nil {
+			return "", err
+		}
+		return string(b), nil
+	default:
+		return "", fmt.Errorf("can't marshal %T (implement encoding.BinaryMarshaler)", value)
+	}
+}
+
+// matchGlob matches s against a Redis style glob pattern supporting *, ?, [...] and
+// backslash escapes. Unlike path.Match, * also matches '/'.
+func matchGlob(pattern, s string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for i := len(s); i >= 0; i-- {
+				if matchGlob(pattern[1:], s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+			pattern, s = pattern[1:], s[1:]
+		case '[':
+			end := strings.IndexByte(pattern, ']')
+			if end < 0 || len(s) == 0 {
+				return false
+			}
+
+			set, negate := pattern[1:end], false
+			if strings.HasPrefix(set, "^") {
+				set, negate = set[1:], true
+			}
+
+			if strings.IndexByte(set, s[0]) >= 0 == negate {
+				return false
+			}
+			pattern, s = pattern[end+1:], s[1:]
+		case '\\':
+			if len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+		default:
+			if len(s) == 0 || pattern[0] != s[0] {
+				return false
+			}
+			pattern, s = pattern[1:], s[1:]
+		}
+	}
+
+	return len(s) == 0
+}
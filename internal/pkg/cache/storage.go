@@ -0,0 +1,77 @@
+package cache
+
+import (
+	"api-gateway-service-ms/config"
+	"api-gateway-service-ms/internal/pkg/logger"
+	"context"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	DRIVER_REDIS  = "redis"
+	DRIVER_MEMORY = "memory"
+)
+
+// ErrNotFound is returned by Get when the key does not exist, for every storage driver
+var ErrNotFound = redis.Nil
+
+// Storage is the key-value backend used by the gateway middlewares and controllers
+type Storage interface {
+	Ping(ctx context.Context) error
+	// Get decodes the JSON value stored at key into obj
+	Get(ctx context.Context, key string, obj interface{}) error
+	Set(ctx context.Context, key string, value interface{}, expiration time.Duration) error
+	SetNX(ctx context.Context, key string, value interface{}, expiration time.Duration) (bool, error)
+	Delete(ctx context.Context, key string) error
+	Exists(ctx context.Context, key string) (bool, error)
+	Incr(ctx context.Context, key string) (int64, error)
+	// TTL follows the Redis convention: -1 for keys without expiry, -2 for missing keys
+	TTL(ctx context.Context, key string) (time.Duration, error)
+	Eval(ctx context.Context, script *Script, keys []string, args ...interface{}) (interface{}, error)
+	// Scan returns every key matching the glob pattern
+	Scan(ctx context.Context, pattern string) ([]string, error)
+	Close() error
+}
+
+// ScriptStore is the view of the in-memory storage given to the Go version of a script.
+// It is used while the storage lock is held, so scripts run atomically.
+type ScriptStore interface {
+	Get(key string) (string, bool)
+	Set(key, value string, expiration time.Duration)
+	Delete(key string) bool
+	Expire(key string, expiration time.Duration) bool
+	TTL(key string) time.Duration
+}
+
+// LocalScript is the Go equivalent of a Lua script for storages without Lua support
+type LocalScript func(store ScriptStore, keys []string, args []interface{}) (interface{}, error)
+
+// Script pairs a Lua script for Redis with its Go equivalent for the in-memory storage
+type Script struct {
+	lua   *redis.Script
+	local LocalScript
+}
+
+func NewScript(src string, local LocalScript) *Script {
+	return &Script{
+		lua:   redis.NewScript(src),
+		local: local,
+	}
+}
+
+// NewStorage creates the storage selected by the cache driver configuration
+func NewStorage(logger *logger.Logger, cfg *config.Config) (Storage, error) {
+	if cfg.Cache.Driver == DRIVER_MEMORY {
+		logger.Warnf("Using in-memory storage, state is not shared between gateway instances")
+		return NewMemoryCache(logger), nil
+	}
+
+	redisCache, err := NewCacheClient(logger, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return redisCache, nil
+}
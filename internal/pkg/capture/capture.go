@@ -0,0 +1,247 @@
+package capture
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	DEFAULT_BUFFER_SIZE = 100
+	DEFAULT_RETENTION   = time.Hour
+)
+
+// Rule selects the requests to capture until it expires. Every non-empty criteria
+// must match: a path prefix, the authenticated user, and a header, with any value if
+// Value is empty.
+type Rule struct {
+	ID      string    `json:"id"`
+	Route   string    `json:"route,omitempty"`
+	UserID  string    `json:"user_id,omitempty"`
+	Header  string    `json:"header,omitempty"`
+	Value   string    `json:"value,omitempty"`
+	Expires time.Time `json:"expires"`
+}
+
+// Match reports whether every criteria matches the request. userID is the verified
+// identity of the caller, empty for anonymous requests, so a user rule never matches
+// anonymous traffic.
+func (r *Rule) Match(req *http.Request, userID string, now time.Time) bool {
+	if now.After(r.Expires) {
+		return false
+	}
+	if r.Route != "" && !strings.HasPrefix(req.URL.Path, r.Route) {
+		return false
+	}
+	if r.UserID != "" && r.UserID != userID {
+		return false
+	}
+	if r.Header != "" {
+		values := req.Header.Values(r.Header)
+		if len(values) == 0 || (r.Value != "" && !contains(values, r.Value)) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Record is a captured request and its response. Headers and bodies are redacted, and
+// bodies are truncated to the maximum capture size.
+type Record struct {
+	ID              string      `json:"id"`
+	RuleID          string      `json:"rule_id"`
+	Time            time.Time   `json:"time"`
+	Duration        float64     `json:"duration_ms"`
+	RequestID       string      `json:"request_id,omitempty"`
+	RemoteAddr      string      `json:"remote_addr"`
+	UserID          string      `json:"user_id,omitempty"`
+	Method          string      `json:"method"`
+	URL             string      `json:"url"`
+	RequestHeaders  http.Header `json:"request_headers"`
+	RequestBody     string      `json:"request_body,omitempty"`
+	RequestTrunc    bool        `json:"request_body_truncated,omitempty"`
+	Status          int         `json:"status"`
+	ResponseHeaders http.Header `json:"response_headers"`
+	ResponseBody    string      `json:"response_body,omitempty"`
+	ResponseTrunc   bool        `json:"response_body_truncated,omitempty"`
+}
+
+// Recorder keeps the capture rules and the latest captures in a ring buffer, and
+// optionally appends the captures to a file
+type Recorder struct {
+	retention time.Duration
+
+	mu      sync.Mutex
+	rules   []Rule
+	records []*Record
+	next    int
+	file    io.WriteCloser
+}
+
+// NewRecorder returns a recorder keeping size captures for the retention period. Zero
+// values select the defaults.
+func NewRecorder(size int, retention time.Duration) *Recorder {
+	if size <= 0 {
+		size = DEFAULT_BUFFER_SIZE
+	}
+	if retention <= 0 {
+		retention = DEFAULT_RETENTION
+	}
+
+	return &Recorder{
+		retention: retention,
+		records:   make([]*Record, 0, size),
+	}
+}
+
+// SetFile appends every capture as a JSON line to w
+func (r *Recorder) SetFile(w io.WriteCloser) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.file = w
+}
+
+// AddRule assigns an ID to the rule and activates it
+func (r *Recorder) AddRule(rule Rule) Rule {
+	rule.ID = uuid.New().String()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.rules = append(r.activeRules(time.Now()), rule)
+	return rule
+}
+
+// Rules returns the rules that haven't expired
+func (r *Recorder) Rules() []Rule {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.rules = r.activeRules(time.Now())
+	return append([]Rule(nil), r.rules...)
+}
+
+// DeleteRule removes a rule, reporting whether it existed
+func (r *Recorder) DeleteRule(id string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, rule := range r.rules {
+		if rule.ID == id {
+			r.rules = append(r.rules[:i], r.rules[i+1:]...)
+			return true
+		}
+	}
+
+	return false
+}
+
+func (r *Recorder) activeRules(now time.Time) []Rule {
+	active := r.rules[:0]
+	for _, rule := range r.rules {
+		if now.Before(rule.Expires) {
+			active = append(active, rule)
+		}
+	}
+
+	return active
+}
+
+// Match returns the first active rule matching the request of the user, or nil
+func (r *Recorder) Match(req *http.Request, userID string) *Rule {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	for _, rule := range r.rules {
+		if rule.Match(req, userID, now) {
+			return &rule
+		}
+	}
+
+	return nil
+}
+
+// Add stores a capture, replacing the oldest once the buffer is full
+func (r *Recorder) Add(record *Record) error {
+	record.ID = uuid.New().String()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.records) < cap(r.records) {
+		r.records = append(r.records, record)
+	} else {
+		r.records[r.next] = record
+		r.next = (r.next + 1) % len(r.records)
+	}
+
+	if r.file == nil {
+		return nil
+	}
+
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.file.Write(append(line, '\n'))
+	return err
+}
+
+// Records returns the captures within the retention period, oldest first, optionally
+// only those of a rule
+func (r *Recorder) Records(ruleID string) []*Record {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	cutoff := time.Now().Add(-r.retention)
+	records := make([]*Record, 0, len(r.records))
+	for i := range r.records {
+		record := r.records[(r.next+i)%len(r.records)]
+		if record.Time.Before(cutoff) || (ruleID != "" && record.RuleID != ruleID) {
+			continue
+		}
+		records = append(records, record)
+	}
+
+	return records
+}
+
+// Clear drops every capture kept in memory
+func (r *Recorder) Clear() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.records = r.records[:0]
+	r.next = 0
+}
+
+// Close closes the capture file, if any
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.file == nil {
+		return nil
+	}
+
+	return r.file.Close()
+}
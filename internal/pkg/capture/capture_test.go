@@ -0,0 +1,46 @@
+package capture
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRecorderMatch(t *testing.T) {
+	recorder := NewRecorder(DEFAULT_BUFFER_SIZE, DEFAULT_RETENTION)
+	expires := time.Now().Add(time.Minute)
+	userRule := recorder.AddRule(Rule{UserID: "42", Expires: expires})
+	headerRule := recorder.AddRule(Rule{Route: "/orders", Header: "X-Partner", Value: "acme", Expires: expires})
+	recorder.AddRule(Rule{Route: "/users", Expires: time.Now().Add(-time.Minute)})
+
+	partner := httptest.NewRequest("GET", "/orders/1", nil)
+	partner.Header.Set("X-Partner", "acme")
+
+	tests := []struct {
+		name   string
+		path   string
+		userID string
+		want   string
+	}{
+		{"verified user", "/users/42", "42", userRule.ID},
+		{"anonymous request", "/users/42", "", ""},
+		{"other user", "/users/42", "7", ""},
+		{"expired rule", "/users/7", "7", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule := recorder.Match(httptest.NewRequest("GET", tt.path, nil), tt.userID)
+			got := ""
+			if rule != nil {
+				got = rule.ID
+			}
+			if got != tt.want {
+				t.Fatalf("got rule %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	if rule := recorder.Match(partner, ""); rule == nil || rule.ID != headerRule.ID {
+		t.Fatal("header rule didn't match the partner request")
+	}
+}
@@ -0,0 +1,243 @@
+package health
+
+import (
+	"api-gateway-service-ms/config"
+	"api-gateway-service-ms/internal/pkg/cache"
+	"api-gateway-service-ms/internal/pkg/logger"
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	STATUS_UP       = "up"
+	STATUS_DOWN     = "down"
+	STATUS_DEGRADED = "degraded"
+
+	REDIS_REQUIRED = "required"
+	REDIS_OPTIONAL = "optional"
+
+	DEFAULT_INTERVAL = 10 * time.Second
+	DEFAULT_TIMEOUT  = 2 * time.Second
+	DEFAULT_PATH     = "/health"
+)
+
+// Check is the outcome of a dependency check
+type Check struct {
+	Status       string    `json:"status"`
+	StatusCode   int       `json:"status_code,omitempty"`
+	ResponseTime string    `json:"response_time,omitempty"`
+	Error        string    `json:"error,omitempty"`
+	CheckedAt    time.Time `json:"checked_at"`
+}
+
+// Snapshot is the outcome of the last round of checks
+type Snapshot struct {
+	Redis     Check            `json:"redis"`
+	Services  map[string]Check `json:"services"`
+	CheckedAt time.Time        `json:"checked_at"`
+}
+
+// Checker checks Redis and the upstream services in the background and decides whether
+// the gateway is ready to receive traffic
+type Checker struct {
+	cfg      *config.Manager
+	cache    cache.Storage
+	logger   *logger.Logger
+	client   *http.Client
+	snapshot atomic.Pointer[Snapshot]
+	draining atomic.Bool
+}
+
+func NewChecker(cfg *config.Manager, cache cache.Storage, logger *logger.Logger) *Checker {
+	return &Checker{
+		cfg:    cfg,
+		cache:  cache,
+		logger: logger,
+		client: &http.Client{},
+	}
+}
+
+// Run checks the dependencies every health.interval until ctx is done
+func (h *Checker) Run(ctx context.Context) {
+	for {
+		h.Check(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(h.interval()):
+		}
+	}
+}
+
+// Check runs a round of checks and keeps its outcome
+func (h *Checker) Check(ctx context.Context) *Snapshot {
+	cfg := h.cfg.Get()
+	timeout := cfg.Health.Timeout
+	if timeout <= 0 {
+		timeout = DEFAULT_TIMEOUT
+	}
+
+	path := cfg.Health.Path
+	if path == "" {
+		path = DEFAULT_PATH
+	}
+
+	snapshot := &Snapshot{
+		Services:  make(map[string]Check, len(cfg.FowardServiceUrl)),
+		CheckedAt: time.Now(),
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		snapshot.Redis = h.checkRedis(ctx, timeout)
+	}()
+
+	for service, serviceURL := range cfg.FowardServiceUrl {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			check := h.checkService(ctx, service, strings.TrimSuffix(serviceURL, "/")+path, timeout)
+
+			mu.Lock()
+			snapshot.Services[service] = check
+			mu.Unlock()
+		}()
+	}
+
+	wg.Wait()
+	h.snapshot.Store(snapshot)
+
+	return snapshot
+}
+
+func (h *Checker) checkRedis(ctx context.Context, timeout time.Duration) Check {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	check := Check{Status: STATUS_UP, CheckedAt: time.Now()}
+	if err := h.cache.Ping(ctx); err != nil {
+		check.Status = STATUS_DOWN
+		check.Error = err.Error()
+		h.logger.Errorf("Redis health check failed: %v", err)
+	}
+	check.ResponseTime = time.Since(check.CheckedAt).String()
+
+	return check
+}
+
+func (h *Checker) checkService(ctx context.Context, service, healthURL string, timeout time.Duration) Check {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	check := Check{Status: STATUS_UP, CheckedAt: time.Now()}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, healthURL, nil)
+	if err != nil {
+		check.Status = STATUS_DOWN
+		check.Error = err.Error()
+		h.logger.Errorf("Error creating request for service %s: %v", service, err)
+		return check
+	}
+
+	resp, err := h.client.Do(req)
+	check.ResponseTime = time.Since(check.CheckedAt).String()
+	if err != nil {
+		check.Status = STATUS_DOWN
+		check.Error = err.Error()
+		h.logger.Errorf("Error checking health of service %s: %v", service, err)
+		return check
+	}
+	resp.Body.Close()
+
+	check.StatusCode = resp.StatusCode
+	if resp.StatusCode != http.StatusOK {
+		check.Status = STATUS_DEGRADED
+		check.Error = "Non-200 status code"
+		h.logger.Warnf("Service %s health check returned status %d", service, resp.StatusCode)
+	}
+
+	return check
+}
+
+// Snapshot returns the outcome of the last round of checks, nil before the first one completes
+func (h *Checker) Snapshot() *Snapshot {
+	return h.snapshot.Load()
+}
+
+// SetDraining marks the gateway as shutting down, so it stops being ready while it
+// finishes the requests in flight
+func (h *Checker) SetDraining(draining bool) {
+	h.draining.Store(draining)
+}
+
+func (h *Checker) Draining() bool {
+	return h.draining.Load()
+}
+
+// Ready reports whether the gateway should receive traffic, with the reasons when it
+// should not
+func (h *Checker) Ready() (bool, []string) {
+	var reasons []string
+	if h.Draining() {
+		reasons = append(reasons, "draining")
+	}
+
+	snapshot := h.Snapshot()
+	if snapshot == nil {
+		return false, append(reasons, "dependencies not checked yet")
+	}
+
+	cfg := h.cfg.Get().Health
+	if cfg.Redis != REDIS_OPTIONAL && snapshot.Redis.Status != STATUS_UP {
+		reasons = append(reasons, "redis is "+snapshot.Redis.Status)
+	}
+
+	for _, service := range cfg.CriticalServices {
+		check, ok := snapshot.Services[service]
+		if !ok {
+			// Added by a reload since the last round of checks
+			reasons = append(reasons, fmt.Sprintf("service %s not checked yet", service))
+		} else if check.Status != STATUS_UP {
+			reasons = append(reasons, fmt.Sprintf("service %s is %s", service, check.Status))
+		}
+	}
+
+	return len(reasons) == 0, reasons
+}
+
+// Status summarizes the snapshot: down when not ready, degraded when a dependency that
+// readiness doesn't depend on is failing, up otherwise
+func (h *Checker) Status() string {
+	if ready, _ := h.Ready(); !ready {
+		return STATUS_DOWN
+	}
+
+	snapshot := h.Snapshot()
+	if snapshot.Redis.Status != STATUS_UP {
+		return STATUS_DEGRADED
+	}
+	for _, check := range snapshot.Services {
+		if check.Status != STATUS_UP {
+			return STATUS_DEGRADED
+		}
+	}
+
+	return STATUS_UP
+}
+
+func (h *Checker) interval() time.Duration {
+	if interval := h.cfg.Get().Health.Interval; interval > 0 {
+		return interval
+	}
+
+	return DEFAULT_INTERVAL
+}
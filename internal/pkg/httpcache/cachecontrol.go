@@ -0,0 +1,109 @@
+package httpcache
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Directives holds the parsed Cache-Control directives, keyed by lowercase name
+type Directives map[string]string
+
+// ParseCacheControl parses every Cache-Control header value of h
+func ParseCacheControl(h http.Header) Directives {
+	directives := make(Directives)
+	for _, value := range h.Values("Cache-Control") {
+		for _, part := range strings.Split(value, ",") {
+			part = strings.TrimSpace(part)
+			if part == "" {
+				continue
+			}
+
+			name, arg, _ := strings.Cut(part, "=")
+			directives[strings.ToLower(strings.TrimSpace(name))] = strings.Trim(strings.TrimSpace(arg), `"`)
+		}
+	}
+
+	return directives
+}
+
+// Has reports whether the directive is present
+func (d Directives) Has(name string) bool {
+	_, ok := d[name]
+	return ok
+}
+
+// Duration returns the delta-seconds argument of the directive
+func (d Directives) Duration(name string) (time.Duration, bool) {
+	arg, ok := d[name]
+	if !ok {
+		return 0, false
+	}
+
+	seconds, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil || seconds < 0 {
+		return 0, false
+	}
+
+	return time.Duration(seconds) * time.Second, true
+}
+
+// Freshness returns how long a response stays fresh in a shared cache, using
+// s-maxage, max-age and then Expires relative to Date (RFC 9111 section 4.2.1)
+func Freshness(h http.Header, now time.Time) time.Duration {
+	directives := ParseCacheControl(h)
+	if directives.Has("no-cache") {
+		return 0
+	}
+
+	if ttl, ok := directives.Duration("s-maxage"); ok {
+		return ttl
+	}
+
+	if ttl, ok := directives.Duration("max-age"); ok {
+		return ttl
+	}
+
+	if expires := h.Get("Expires"); expires != "" {
+		expiresAt, err := http.ParseTime(expires)
+		if err != nil {
+			// Invalid Expires values mean already expired
+			return 0
+		}
+
+		date := now
+		if value := h.Get("Date"); value != "" {
+			if parsed, err := http.ParseTime(value); err == nil {
+				date = parsed
+			}
+		}
+
+		if ttl := expiresAt.Sub(date); ttl > 0 {
+			return ttl
+		}
+	}
+
+	return 0
+}
+
+// VaryHeaders returns the canonical header names listed in Vary, and false for "Vary: *"
+func VaryHeaders(h http.Header) ([]string, bool) {
+	var names []string
+	for _, value := range h.Values("Vary") {
+		for _, name := range strings.Split(value, ",") {
+			name = strings.TrimSpace(name)
+			if name == "" {
+				continue
+			}
+
+			if name == "*" {
+				return nil, false
+			}
+
+			names = append(names, http.CanonicalHeaderKey(name))
+		}
+	}
+
+	return names, true
+}
@@ -0,0 +1,93 @@
+package httpcache
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Entry is a stored response together with its freshness information
+type Entry struct {
+	StatusCode           int           `json:"status_code"`
+	Header               http.Header   `json:"header"`
+	Body                 []byte        `json:"body"`
+	StoredAt             time.Time     `json:"stored_at"`
+	FreshUntil           time.Time     `json:"fresh_until"`
+	StaleWhileRevalidate time.Duration `json:"stale_while_revalidate"`
+	StaleIfError         time.Duration `json:"stale_if_error"`
+	Tags                 []string      `json:"tags,omitempty"`
+}
+
+// MarshalBinary lets the entry be stored in the cache as JSON
+func (e Entry) MarshalBinary() ([]byte, error) {
+	return json.Marshal(e)
+}
+
+// Fresh reports whether the entry can be served without revalidation
+func (e *Entry) Fresh(now time.Time) bool {
+	return now.Before(e.FreshUntil)
+}
+
+// UsableWhileRevalidating reports whether the stale entry may be served while
+// it is refreshed in the background
+func (e *Entry) UsableWhileRevalidating(now time.Time) bool {
+	return now.Before(e.FreshUntil.Add(e.StaleWhileRevalidate))
+}
+
+// UsableOnError reports whether the stale entry may be served when the upstream fails
+func (e *Entry) UsableOnError(now time.Time) bool {
+	return now.Before(e.FreshUntil.Add(e.StaleIfError))
+}
+
+// Age returns the number of seconds since the entry was stored
+func (e *Entry) Age(now time.Time) int64 {
+	return int64(now.Sub(e.StoredAt).Seconds())
+}
+
+// ETag returns the entity tag of the stored response
+func (e *Entry) ETag() string {
+	return e.Header.Get("ETag")
+}
+
+// LastModified returns the Last-Modified value of the stored response
+func (e *Entry) LastModified() string {
+	return e.Header.Get("Last-Modified")
+}
+
+// HasValidators reports whether the entry can be revalidated with a conditional request
+func (e *Entry) HasValidators() bool {
+	return e.ETag() != "" || e.LastModified() != ""
+}
+
+// MatchesConditional reports whether the client already has this version of the entry
+func (e *Entry) MatchesConditional(r *http.Request) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		etag := strings.TrimPrefix(e.ETag(), "W/")
+		if etag == "" {
+			return false
+		}
+
+		// If-None-Match uses the weak comparison
+		for _, candidate := range strings.Split(inm, ",") {
+			candidate = strings.TrimSpace(candidate)
+			if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	if ims := r.Header.Get("If-Modified-Since"); ims != "" && e.LastModified() != "" {
+		since, err := http.ParseTime(ims)
+		if err != nil {
+			return false
+		}
+
+		modified, err := http.ParseTime(e.LastModified())
+		return err == nil && !modified.After(since)
+	}
+
+	return false
+}
@@ -0,0 +1,112 @@
+package httpcache
+
+import (
+	"api-gateway-service-ms/config"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// ANONYMOUS_USER scopes per-user keys of unauthenticated requests
+const ANONYMOUS_USER = "anonymous"
+
+// KeyOptions selects the request parts that make up a cache key besides method and URL
+type KeyOptions struct {
+	// Headers are request headers always included in the key
+	Headers []string
+	// User scopes the key to a user for private data
+	User string
+	// Tenant scopes the key to a tenant
+	Tenant string
+}
+
+// PrimaryKey returns the cache key of a request before Vary is applied, e.g.
+// "GET /catalog/items?page=1|user=42". Query parameters are sorted.
+func PrimaryKey(r *http.Request, opts KeyOptions) string {
+	var b strings.Builder
+	b.WriteString(r.Method)
+	b.WriteString(" ")
+	b.WriteString(r.URL.Path)
+
+	if query := r.URL.Query(); len(query) > 0 {
+		b.WriteString("?")
+		b.WriteString(query.Encode())
+	}
+
+	headers := append([]string(nil), opts.Headers...)
+	sort.Strings(headers)
+	for _, name := range headers {
+		b.WriteString("|")
+		b.WriteString(strings.ToLower(name))
+		b.WriteString("=")
+		b.WriteString(r.Header.Get(name))
+	}
+
+	if opts.Tenant != "" {
+		b.WriteString("|tenant=")
+		b.WriteString(opts.Tenant)
+	}
+
+	if opts.User != "" {
+		b.WriteString("|user=")
+		b.WriteString(opts.User)
+	}
+
+	return b.String()
+}
+
+// VariantKey extends the primary key with the request values of the Vary headers
+func VariantKey(primary string, vary []string, h http.Header) string {
+	if len(vary) == 0 {
+		return primary
+	}
+
+	names := append([]string(nil), vary...)
+	sort.Strings(names)
+
+	var b strings.Builder
+	b.WriteString(primary)
+	for _, name := range names {
+		b.WriteString("|vary:")
+		b.WriteString(strings.ToLower(name))
+		b.WriteString("=")
+		b.WriteString(strings.Join(h.Values(name), ","))
+	}
+
+	return b.String()
+}
+
+// MatchRoute returns the route with the longest path prefix matching the path, or nil
+func MatchRoute(routes []config.ResponseCacheRouteConfig, path string) *config.ResponseCacheRouteConfig {
+	var matched *config.ResponseCacheRouteConfig
+	for i, route := range routes {
+		if strings.HasPrefix(path, route.Path) && (matched == nil || len(route.Path) > len(matched.Path)) {
+			matched = &routes[i]
+		}
+	}
+
+	return matched
+}
+
+// RouteKeyOptions returns the key composition configured for the route.
+// userID is empty for unauthenticated requests.
+func RouteKeyOptions(route *config.ResponseCacheRouteConfig, r *http.Request, userID string) KeyOptions {
+	var opts KeyOptions
+	if route == nil {
+		return opts
+	}
+
+	opts.Headers = route.KeyHeaders
+	if route.TenantHeader != "" {
+		opts.Tenant = r.Header.Get(route.TenantHeader)
+	}
+
+	if route.PerUser {
+		opts.User = userID
+		if opts.User == "" {
+			opts.User = ANONYMOUS_USER
+		}
+	}
+
+	return opts
+}
@@ -1,6 +1,7 @@
 package logger
 
 import (
+	"api-gateway-service-ms/config"
 	"context"
 	"fmt"
 	"io"
@@ -8,9 +9,11 @@ import (
 	"runtime"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Standard field names
@@ -28,6 +31,8 @@ const (
 	FieldFile       = "file"
 	FieldLine       = "line"
 	FieldFunc       = "func"
+	FieldTraceID    = "trace_id"
+	FieldSpanID     = "span_id"
 )
 
 var (
@@ -136,6 +141,10 @@ type LoggerConfig struct {
 	EnableJSON bool
 	// Fields are additional fields to include with every log entry
 	Fields map[string]interface{}
+	// Redaction masks sensitive data in every log entry
+	Redaction config.RedactionConfig
+	// Sampling limits the request log lines written under load
+	Sampling config.LogSamplingConfig
 }
 
 // Logger wraps logrus.Logger
@@ -143,6 +152,15 @@ type Logger struct {
 	*logrus.Logger
 	config    LoggerConfig
 	baseEntry *logrus.Entry
+	redactor  atomic.Pointer[Redactor]
+	sampling  atomic.Pointer[config.LogSamplingConfig]
+	dedupe    *dedupeFormatter
+
+	// debugLogger shares the output, formatter and hooks, and logs requests matching
+	// a debug rule at debug level
+	debugLogger *logrus.Logger
+	debugMu     sync.Mutex
+	debugRules  []DebugRule
 }
 
 // New creates a new configured logger
@@ -156,18 +174,21 @@ func New(config LoggerConfig) *Logger {
 	logger.SetLevel(config.Level)
 
 	// In production or when explicitly enabled, use JSON formatter
+	var formatter logrus.Formatter
 	if config.Env == "production" || config.EnableJSON {
-		logger.SetFormatter(&logrus.JSONFormatter{
+		formatter = &logrus.JSONFormatter{
 			TimestampFormat:   time.RFC3339Nano,
 			DisableHTMLEscape: true,
-		})
+		}
 	} else {
 		// In development, use a more readable format
-		logger.SetFormatter(&CustomFormatter{
+		formatter = &CustomFormatter{
 			TimestampFormat: time.RFC3339Nano,
 			ShowColors:      true,
-		})
+		}
 	}
+	dedupe := newDedupeFormatter(formatter)
+	logger.SetFormatter(dedupe)
 
 	// Create base entry with service information
 	baseFields := logrus.Fields{
@@ -183,11 +204,40 @@ func New(config LoggerConfig) *Logger {
 		Logger:    logger,
 		config:    config,
 		baseEntry: logger.WithFields(baseFields),
+		dedupe:    dedupe,
 	}
+	l.SetSampling(config.Sampling)
+
+	// Mask the built-in headers and patterns until the configured redaction is set
+	redactor, _ := NewRedactor(config.Redaction)
+	l.redactor.Store(redactor)
+	logger.AddHook(redactionHook{logger: l})
+
+	l.debugLogger = logrus.New()
+	l.debugLogger.SetOutput(config.Output)
+	l.debugLogger.SetFormatter(dedupe)
+	l.debugLogger.SetLevel(logrus.DebugLevel)
+	l.debugLogger.ReplaceHooks(logger.Hooks)
 
 	return l
 }
 
+// SetRedaction replaces the redaction settings, e.g. after a configuration reload
+func (l *Logger) SetRedaction(cfg config.RedactionConfig) error {
+	redactor, err := NewRedactor(cfg)
+	if err != nil {
+		return err
+	}
+
+	l.redactor.Store(redactor)
+	return nil
+}
+
+// Redactor returns the redactor to apply to request and response data before logging it
+func (l *Logger) Redactor() *Redactor {
+	return l.redactor.Load()
+}
+
 // SetupLogger initializes the default logger
 func SetupLogger(config LoggerConfig) *Logger {
 	once.Do(func() {
@@ -207,9 +257,13 @@ func (l *Logger) WithUserID(userID string) *logrus.Entry {
 	return l.baseEntry.WithField(FieldUserID, userID)
 }
 
-// WithContext adds context-specific fields to the logger entry
+// WithContext adds context-specific fields to the logger entry. Requests marked by
+// WithDebug are logged at debug level.
 func (l *Logger) WithContext(ctx context.Context) *logrus.Entry {
 	entry := l.baseEntry
+	if ctx != nil && IsDebug(ctx) {
+		entry = l.Debugging(entry)
+	}
 
 	// Extract values from context if they exist
 	// This is where you'd pull request IDs, correlation IDs, etc. from your context
@@ -220,11 +274,25 @@ func (l *Logger) WithContext(ctx context.Context) *logrus.Entry {
 		if userID, ok := ctx.Value("user_id").(string); ok && userID != "" {
 			entry = entry.WithField(FieldUserID, userID)
 		}
+		entry = entry.WithFields(TraceFields(ctx))
 	}
 
 	return entry
 }
 
+// TraceFields returns the trace and span IDs of the span in ctx, if any
+func TraceFields(ctx context.Context) logrus.Fields {
+	spanContext := trace.SpanContextFromContext(ctx)
+	if !spanContext.IsValid() {
+		return logrus.Fields{}
+	}
+
+	return logrus.Fields{
+		FieldTraceID: spanContext.TraceID().String(),
+		FieldSpanID:  spanContext.SpanID().String(),
+	}
+}
+
 // WithError adds an error to the logger entry
 func (l *Logger) WithError(err error) *logrus.Entry {
 	return l.baseEntry.WithError(err)
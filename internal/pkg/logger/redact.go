@@ -0,0 +1,254 @@
+package logger
+
+import (
+	"api-gateway-service-ms/config"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	REDACTED              = "[REDACTED]"
+	DEFAULT_MAX_BODY_SIZE = 1024
+)
+
+var (
+	defaultRedactedHeaders = []string{"Authorization", "Cookie", "Set-Cookie"}
+
+	emailPattern = regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`)
+	// cardPattern matches 13 to 19 digits, optionally grouped by spaces or dashes.
+	// Matches failing the Luhn check, like IDs and timestamps, are kept.
+	cardPattern = regexp.MustCompile(`\b(?:\d[ -]?){12,18}\d\b`)
+)
+
+// Redactor masks sensitive data in log entries
+type Redactor struct {
+	headers    map[string]bool
+	jsonFields [][]string
+	// fieldPatterns match the configured fields by name in JSON that can't be parsed,
+	// e.g. a truncated body
+	fieldPatterns []*regexp.Regexp
+	patterns      []*regexp.Regexp
+	maxBodySize   int
+}
+
+// NewRedactor returns a redactor masking the built-in and the configured headers,
+// JSON fields and patterns
+func NewRedactor(cfg config.RedactionConfig) (*Redactor, error) {
+	r := &Redactor{
+		headers:     make(map[string]bool),
+		maxBodySize: cfg.MaxBodySize,
+	}
+
+	if r.maxBodySize <= 0 {
+		r.maxBodySize = DEFAULT_MAX_BODY_SIZE
+	}
+
+	for _, header := range append(defaultRedactedHeaders, cfg.Headers...) {
+		r.headers[http.CanonicalHeaderKey(header)] = true
+	}
+
+	for _, field := range cfg.JSONFields {
+		path := strings.Split(field, ".")
+		r.jsonFields = append(r.jsonFields, path)
+
+		if name := path[len(path)-1]; name != "*" {
+			r.fieldPatterns = append(r.fieldPatterns, regexp.MustCompile(
+				`("`+regexp.QuoteMeta(name)+`"\s*:\s*)("(?:[^"\\]|\\.)*"?|[^,}\]\s]+)`,
+			))
+		}
+	}
+
+	for _, pattern := range cfg.Patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redaction pattern %q: %w", pattern, err)
+		}
+		r.patterns = append(r.patterns, re)
+	}
+
+	return r, nil
+}
+
+// String masks card numbers, email addresses and the configured patterns
+func (r *Redactor) String(s string) string {
+	s = cardPattern.ReplaceAllStringFunc(s, func(match string) string {
+		if luhnValid(match) {
+			return REDACTED
+		}
+		return match
+	})
+	s = emailPattern.ReplaceAllString(s, REDACTED)
+
+	for _, pattern := range r.patterns {
+		s = pattern.ReplaceAllString(s, REDACTED)
+	}
+
+	return s
+}
+
+// Header returns a copy of the headers with the denied headers masked and the patterns
+// masked in the other values
+func (r *Redactor) Header(header http.Header) http.Header {
+	redacted := make(http.Header, len(header))
+	for name, values := range header {
+		masked := make([]string, len(values))
+		for i, value := range values {
+			if r.headers[http.CanonicalHeaderKey(name)] {
+				masked[i] = REDACTED
+			} else {
+				masked[i] = r.String(value)
+			}
+		}
+		redacted[name] = masked
+	}
+
+	return redacted
+}
+
+// Mask returns the body with the configured fields of a JSON body and the patterns masked
+func (r *Redactor) Mask(contentType string, body []byte) string {
+	if len(r.jsonFields) > 0 && isJSON(contentType, body) {
+		if masked, err := r.maskJSON(body); err == nil {
+			body = masked
+		} else {
+			for _, pattern := range r.fieldPatterns {
+				body = pattern.ReplaceAll(body, []byte(`${1}"`+REDACTED+`"`))
+			}
+		}
+	}
+
+	return r.String(string(body))
+}
+
+// Body returns a request or response body fit for logging: masked, then truncated to
+// the maximum size
+func (r *Redactor) Body(contentType string, body []byte) string {
+	s := r.Mask(contentType, body)
+	if len(s) <= r.maxBodySize {
+		return s
+	}
+
+	// Cut on a rune boundary so the log line stays valid UTF-8
+	cut := r.maxBodySize
+	for cut > 0 && !utf8.RuneStart(s[cut]) {
+		cut--
+	}
+
+	return fmt.Sprintf("%s...(truncated %d bytes)", s[:cut], len(s)-cut)
+}
+
+func (r *Redactor) maskJSON(body []byte) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+
+	for _, path := range r.jsonFields {
+		maskPath(value, path)
+	}
+
+	return json.Marshal(value)
+}
+
+// maskPath masks the values found at path, looking into every element of arrays
+func maskPath(value interface{}, path []string) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, child := range v {
+			if path[0] != "*" && path[0] != key {
+				continue
+			}
+
+			if len(path) == 1 {
+				v[key] = REDACTED
+			} else {
+				maskPath(child, path[1:])
+			}
+		}
+	case []interface{}:
+		for _, child := range v {
+			maskPath(child, path)
+		}
+	}
+}
+
+// field masks a log field by name, or the patterns in its value
+func (r *Redactor) field(name string, value interface{}) interface{} {
+	if r.headers[http.CanonicalHeaderKey(name)] {
+		return REDACTED
+	}
+
+	switch v := value.(type) {
+	case string:
+		return r.String(v)
+	case http.Header:
+		return r.Header(v)
+	case error:
+		if masked := r.String(v.Error()); masked != v.Error() {
+			return masked
+		}
+	}
+
+	return value
+}
+
+func isJSON(contentType string, body []byte) bool {
+	if strings.Contains(contentType, "json") {
+		return true
+	}
+
+	body = bytes.TrimSpace(body)
+	return len(body) > 0 && (body[0] == '{' || body[0] == '[')
+}
+
+// luhnValid reports whether the digits of s pass the Luhn checksum of card numbers
+func luhnValid(s string) bool {
+	sum, double := 0, false
+	for i := len(s) - 1; i >= 0; i-- {
+		if s[i] < '0' || s[i] > '9' {
+			continue
+		}
+
+		digit := int(s[i] - '0')
+		if double {
+			digit *= 2
+			if digit > 9 {
+				digit -= 9
+			}
+		}
+		sum += digit
+		double = !double
+	}
+
+	return sum%10 == 0
+}
+
+// redactionHook masks every entry before it is formatted, so no log statement can
+// bypass redaction
+type redactionHook struct {
+	logger *Logger
+}
+
+func (h redactionHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (h redactionHook) Fire(entry *logrus.Entry) error {
+	r := h.logger.Redactor()
+	entry.Message = r.String(entry.Message)
+	for name, value := range entry.Data {
+		entry.Data[name] = r.field(name, value)
+	}
+
+	return nil
+}
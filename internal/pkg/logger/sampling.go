@@ -0,0 +1,220 @@
+package logger
+
+import (
+	"api-gateway-service-ms/config"
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	FieldSuppressed = "suppressed"
+
+	DEFAULT_SLOW_THRESHOLD = time.Second
+	// DEDUPE_MAX_MESSAGES bounds the messages remembered for deduplication, messages past
+	// it are logged without deduplication until remembered ones leave the window
+	DEDUPE_MAX_MESSAGES = 1024
+)
+
+// DebugRule logs the requests to a path prefix and/or of a user at debug level until
+// it expires, whatever the global level
+type DebugRule struct {
+	Route   string    `json:"route,omitempty"`
+	UserID  string    `json:"user_id,omitempty"`
+	Expires time.Time `json:"expires"`
+}
+
+func (r DebugRule) matches(path, userID string, now time.Time) bool {
+	if now.After(r.Expires) {
+		return false
+	}
+	if r.Route != "" && !strings.HasPrefix(path, r.Route) {
+		return false
+	}
+	if r.UserID != "" && r.UserID != userID {
+		return false
+	}
+
+	return r.Route != "" || r.UserID != ""
+}
+
+type debugKey struct{}
+
+// WithDebug marks the request context for debug logging
+func WithDebug(ctx context.Context) context.Context {
+	return context.WithValue(ctx, debugKey{}, true)
+}
+
+// IsDebug reports whether the request context is marked for debug logging
+func IsDebug(ctx context.Context) bool {
+	debug, _ := ctx.Value(debugKey{}).(bool)
+	return debug
+}
+
+// SetSampling replaces the sampling settings, e.g. after a configuration reload
+func (l *Logger) SetSampling(cfg config.LogSamplingConfig) {
+	l.sampling.Store(&cfg)
+
+	window := time.Duration(0)
+	if cfg.Enabled {
+		window = cfg.DedupeWindow
+	}
+	l.dedupe.window.Store(int64(window))
+}
+
+// SamplingEnabled reports whether request log lines are sampled
+func (l *Logger) SamplingEnabled() bool {
+	cfg := l.sampling.Load()
+	return cfg != nil && cfg.Enabled
+}
+
+// SampleRequest reports whether the completion of a request should be logged. Failed,
+// slow and debugged requests are always logged, successful ones at the sampling rate.
+func (l *Logger) SampleRequest(status int, duration time.Duration, debug bool) bool {
+	cfg := l.sampling.Load()
+	if cfg == nil || !cfg.Enabled || debug || status >= 400 {
+		return true
+	}
+
+	threshold := cfg.SlowThreshold
+	if threshold <= 0 {
+		threshold = DEFAULT_SLOW_THRESHOLD
+	}
+	if duration >= threshold {
+		return true
+	}
+
+	return rand.Float64() < cfg.Rate
+}
+
+// AddDebugRule turns on debug logging for the matching requests until the rule expires
+func (l *Logger) AddDebugRule(rule DebugRule) {
+	l.debugMu.Lock()
+	defer l.debugMu.Unlock()
+
+	l.debugRules = append(l.activeDebugRules(time.Now()), rule)
+}
+
+// DebugRules returns the rules that haven't expired
+func (l *Logger) DebugRules() []DebugRule {
+	l.debugMu.Lock()
+	defer l.debugMu.Unlock()
+
+	l.debugRules = l.activeDebugRules(time.Now())
+	return append([]DebugRule(nil), l.debugRules...)
+}
+
+// ClearDebugRules removes every debug rule
+func (l *Logger) ClearDebugRules() {
+	l.debugMu.Lock()
+	defer l.debugMu.Unlock()
+
+	l.debugRules = nil
+}
+
+func (l *Logger) activeDebugRules(now time.Time) []DebugRule {
+	active := l.debugRules[:0]
+	for _, rule := range l.debugRules {
+		if now.Before(rule.Expires) {
+			active = append(active, rule)
+		}
+	}
+
+	return active
+}
+
+// DebugEnabled reports whether a debug rule matches the request path and user
+func (l *Logger) DebugEnabled(path, userID string) bool {
+	l.debugMu.Lock()
+	defer l.debugMu.Unlock()
+
+	now := time.Now()
+	for _, rule := range l.debugRules {
+		if rule.matches(path, userID, now) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Debugging returns a copy of the entry logging at debug level whatever the global level
+func (l *Logger) Debugging(entry *logrus.Entry) *logrus.Entry {
+	debugEntry := entry.Dup()
+	debugEntry.Logger = l.debugLogger
+	return debugEntry
+}
+
+// dedupeFormatter drops repeats of an error or warning message and error within the
+// window, and adds the number dropped to the next occurrence logged. Request log lines
+// are never dropped, their volume is controlled by sampling.
+type dedupeFormatter struct {
+	next   logrus.Formatter
+	window atomic.Int64
+
+	mu   sync.Mutex
+	seen map[string]*dedupeState
+}
+
+type dedupeState struct {
+	since      time.Time
+	suppressed int
+}
+
+func newDedupeFormatter(next logrus.Formatter) *dedupeFormatter {
+	return &dedupeFormatter{
+		next: next,
+		seen: make(map[string]*dedupeState),
+	}
+}
+
+func (f *dedupeFormatter) Format(entry *logrus.Entry) ([]byte, error) {
+	window := time.Duration(f.window.Load())
+	if window <= 0 || entry.Level > logrus.WarnLevel {
+		return f.next.Format(entry)
+	}
+
+	if _, ok := entry.Data[FieldStatusCode]; ok {
+		return f.next.Format(entry)
+	}
+
+	key := entry.Level.String() + ":" + entry.Message
+	if err, ok := entry.Data[logrus.ErrorKey]; ok {
+		key += ":" + fmt.Sprint(err)
+	}
+
+	f.mu.Lock()
+	state, ok := f.seen[key]
+	if ok && entry.Time.Sub(state.since) < window {
+		state.suppressed++
+		f.mu.Unlock()
+		// Nothing is written for an empty line
+		return nil, nil
+	}
+
+	if !ok && len(f.seen) >= DEDUPE_MAX_MESSAGES {
+		for k, s := range f.seen {
+			if entry.Time.Sub(s.since) >= window {
+				delete(f.seen, k)
+			}
+		}
+	}
+	// When every remembered message is still within the window, new messages are logged
+	// without being remembered
+	if ok || len(f.seen) < DEDUPE_MAX_MESSAGES {
+		f.seen[key] = &dedupeState{since: entry.Time}
+	}
+	f.mu.Unlock()
+
+	if ok && state.suppressed > 0 {
+		entry.Data[FieldSuppressed] = state.suppressed
+	}
+
+	return f.next.Format(entry)
+}
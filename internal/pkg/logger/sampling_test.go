@@ -0,0 +1,97 @@
+package logger
+
+import (
+	"api-gateway-service-ms/config"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func newDedupeLogger(output *bytes.Buffer) *Logger {
+	return New(LoggerConfig{
+		Level:      logrus.InfoLevel,
+		Output:     output,
+		EnableJSON: true,
+		Sampling:   config.LogSamplingConfig{Enabled: true, Rate: 1, DedupeWindow: time.Minute},
+	})
+}
+
+func logLines(t *testing.T, output *bytes.Buffer) []map[string]interface{} {
+	t.Helper()
+
+	var lines []map[string]interface{}
+	for _, raw := range strings.Split(strings.TrimSpace(output.String()), "\n") {
+		if raw == "" {
+			continue
+		}
+		var line map[string]interface{}
+		if err := json.Unmarshal([]byte(raw), &line); err != nil {
+			t.Fatalf("invalid log line %q: %v", raw, err)
+		}
+		lines = append(lines, line)
+	}
+
+	return lines
+}
+
+func TestDedupeKeepsRequestLines(t *testing.T) {
+	var output bytes.Buffer
+	l := newDedupeLogger(&output)
+
+	for _, path := range []string{"/user/1", "/user/2", "/user/2"} {
+		l.WithFields(map[string]interface{}{FieldPath: path, FieldStatusCode: 404}).Warn("Client error")
+	}
+	l.WithFields(map[string]interface{}{FieldPath: "/order", FieldStatusCode: 502}).Error("Server error")
+
+	if lines := logLines(t, &output); len(lines) != 4 {
+		t.Fatalf("got %d request lines, want all 4", len(lines))
+	}
+}
+
+func TestDedupeDropsRepeatedErrors(t *testing.T) {
+	var output bytes.Buffer
+	l := newDedupeLogger(&output)
+
+	refused := errors.New("connection refused")
+	for range 3 {
+		l.WithError(refused).Error("Redis command failed")
+	}
+	l.WithError(errors.New("timeout")).Error("Redis command failed")
+
+	lines := logLines(t, &output)
+	if len(lines) != 2 {
+		t.Fatalf("got %d lines, want one per distinct error", len(lines))
+	}
+	if lines[0]["error"] != "connection refused" || lines[1]["error"] != "timeout" {
+		t.Fatalf("got lines %v, want the first of each error", lines)
+	}
+}
+
+func TestDedupeBoundsRememberedMessages(t *testing.T) {
+	var output bytes.Buffer
+	l := newDedupeLogger(&output)
+
+	for i := range DEDUPE_MAX_MESSAGES + 10 {
+		l.Errorf("Order %d failed", i)
+	}
+	l.Errorf("Order %d failed", DEDUPE_MAX_MESSAGES+5)
+
+	if got := len(l.dedupe.seen); got > DEDUPE_MAX_MESSAGES {
+		t.Fatalf("remembered %d messages, want at most %d", got, DEDUPE_MAX_MESSAGES)
+	}
+	if lines := logLines(t, &output); len(lines) != DEDUPE_MAX_MESSAGES+11 {
+		t.Fatalf("got %d lines, want the messages past the bound all logged", len(lines))
+	}
+
+	// Remembered messages are still deduplicated
+	output.Reset()
+	l.Errorf("Order %d failed", 0)
+	if output.Len() != 0 {
+		t.Fatalf("got %q, want the remembered message dropped", output.String())
+	}
+}
@@ -0,0 +1,247 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/redis/go-redis/v9"
+)
+
+const NAMESPACE = "gateway"
+
+// Outcomes of requests with an idempotency key
+const (
+	IDEMPOTENCY_REPLAYED = "replayed"
+	IDEMPOTENCY_STORED   = "stored"
+	IDEMPOTENCY_CONFLICT = "conflict"
+	IDEMPOTENCY_MISMATCH = "mismatch"
+)
+
+// Route labels of requests that don't match a route template, keeping cardinality bounded
+const (
+	ROUTE_UNMATCHED = "unmatched"
+	NO_UPSTREAM     = ""
+)
+
+// Metrics holds the gateway collectors on a dedicated registry
+type Metrics struct {
+	registry *prometheus.Registry
+
+	requests         *prometheus.CounterVec
+	requestDuration  *prometheus.HistogramVec
+	requestsInFlight *prometheus.GaugeVec
+
+	upstreamAttempts *prometheus.CounterVec
+	upstreamErrors   *prometheus.CounterVec
+	upstreamDuration *prometheus.HistogramVec
+
+	ratelimitRejections prometheus.Counter
+	idempotency         *prometheus.CounterVec
+	priorityShed        *prometheus.CounterVec
+
+	cacheCommandDuration *prometheus.HistogramVec
+}
+
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: NAMESPACE,
+			Name:      "http_requests_total",
+			Help:      "Requests handled by the gateway.",
+		}, []string{"route", "method", "status_class", "upstream"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: NAMESPACE,
+			Name:      "http_request_duration_seconds",
+			Help:      "Latency of requests handled by the gateway.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"route", "method", "status_class", "upstream"}),
+		requestsInFlight: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: NAMESPACE,
+			Name:      "http_requests_in_flight",
+			Help:      "Requests currently being handled by the gateway.",
+		}, []string{"route", "method", "upstream"}),
+		upstreamAttempts: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: NAMESPACE,
+			Name:      "upstream_attempts_total",
+			Help:      "Requests sent to upstream services.",
+		}, []string{"upstream"}),
+		upstreamErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: NAMESPACE,
+			Name:      "upstream_errors_total",
+			Help:      "Upstream requests that failed without a response, by reason.",
+		}, []string{"upstream", "reason"}),
+		upstreamDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: NAMESPACE,
+			Name:      "upstream_request_duration_seconds",
+			Help:      "Latency of upstream services until the response headers.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"upstream", "status_class"}),
+		ratelimitRejections: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: NAMESPACE,
+			Name:      "ratelimit_rejections_total",
+			Help:      "Requests rejected by the rate limiter.",
+		}),
+		idempotency: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: NAMESPACE,
+			Name:      "idempotency_requests_total",
+			Help:      "Requests with an idempotency key, by outcome: replayed, stored, conflict or mismatch.",
+		}, []string{"result"}),
+		priorityShed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: NAMESPACE,
+			Name:      "priority_shed_total",
+			Help:      "Requests shed by the priority scheduler, by priority class.",
+		}, []string{"class"}),
+		cacheCommandDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: NAMESPACE,
+			Name:      "cache_command_duration_seconds",
+			Help:      "Latency of Redis commands.",
+			Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+		}, []string{"command", "status"}),
+	}
+
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.requests,
+		m.requestDuration,
+		m.requestsInFlight,
+		m.upstreamAttempts,
+		m.upstreamErrors,
+		m.upstreamDuration,
+		m.ratelimitRejections,
+		m.idempotency,
+		m.priorityShed,
+		m.cacheCommandDuration,
+	)
+
+	return m
+}
+
+// Handler serves the metrics in the Prometheus exposition format
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// StartRequest counts a request in flight and returns a function recording its outcome.
+// route must be a route template, e.g. "/user/*path", never the raw path.
+func (m *Metrics) StartRequest(route, method, upstream string) func(status int) {
+	start := time.Now()
+	inFlight := m.requestsInFlight.WithLabelValues(route, method, upstream)
+	inFlight.Inc()
+
+	return func(status int) {
+		inFlight.Dec()
+
+		class := StatusClass(status)
+		m.requests.WithLabelValues(route, method, class, upstream).Inc()
+		m.requestDuration.WithLabelValues(route, method, class, upstream).Observe(time.Since(start).Seconds())
+	}
+}
+
+// RatelimitRejected counts a request rejected by the rate limiter
+func (m *Metrics) RatelimitRejected() {
+	m.ratelimitRejections.Inc()
+}
+
+// Idempotency counts a request with an idempotency key by outcome
+func (m *Metrics) Idempotency(result string) {
+	m.idempotency.WithLabelValues(result).Inc()
+}
+
+// PriorityShed counts a request shed in a priority class
+func (m *Metrics) PriorityShed(class string) {
+	m.priorityShed.WithLabelValues(class).Inc()
+}
+
+// Transport instruments the requests sent to an upstream service
+func (m *Metrics) Transport(upstream string, next http.RoundTripper) http.RoundTripper {
+	return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		m.upstreamAttempts.WithLabelValues(upstream).Inc()
+
+		start := time.Now()
+		resp, err := next.RoundTrip(req)
+		if err != nil {
+			m.upstreamErrors.WithLabelValues(upstream, errorReason(err)).Inc()
+			return nil, err
+		}
+
+		m.upstreamDuration.WithLabelValues(upstream, StatusClass(resp.StatusCode)).Observe(time.Since(start).Seconds())
+		return resp, nil
+	})
+}
+
+// RedisHook returns a go-redis hook observing the latency of every command
+func (m *Metrics) RedisHook() redis.Hook {
+	return redisHook{metrics: m}
+}
+
+// StatusClass returns the class of a status code, e.g. "2xx"
+func StatusClass(status int) string {
+	if status < 100 || status > 599 {
+		return "unknown"
+	}
+
+	return strconv.Itoa(status/100) + "xx"
+}
+
+func errorReason(err error) string {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	default:
+		return "connection"
+	}
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+type redisHook struct {
+	metrics *Metrics
+}
+
+func (h redisHook) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (h redisHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		start := time.Now()
+		err := next(ctx, cmd)
+		h.observe(cmd.Name(), err, time.Since(start))
+		return err
+	}
+}
+
+func (h redisHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		start := time.Now()
+		err := next(ctx, cmds)
+		h.observe("pipeline", err, time.Since(start))
+		return err
+	}
+}
+
+func (h redisHook) observe(command string, err error, duration time.Duration) {
+	status := "ok"
+	if err != nil && !errors.Is(err, redis.Nil) {
+		status = "error"
+	}
+
+	h.metrics.cacheCommandDuration.WithLabelValues(strings.ToLower(command), status).Observe(duration.Seconds())
+}
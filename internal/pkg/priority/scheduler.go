@@ -0,0 +1,277 @@
+package priority
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrShed is returned when a request is dropped to make room for higher priority traffic
+	ErrShed = errors.New("request shed due to overload")
+	// ErrQueueTimeout is returned when a request waited too long for a free slot
+	ErrQueueTimeout = errors.New("request timed out waiting in priority queue")
+)
+
+// Class describes a priority class and its share of the gateway capacity
+type Class struct {
+	Name   string
+	Weight int
+}
+
+// ClassStats holds the counters of a single priority class
+type ClassStats struct {
+	Weight   int    `json:"weight"`
+	Queued   int    `json:"queued"`
+	InFlight int    `json:"in_flight"`
+	Admitted uint64 `json:"admitted"`
+	Shed     uint64 `json:"shed"`
+}
+
+// Stats is a snapshot of the scheduler state
+type Stats struct {
+	Capacity int                   `json:"capacity"`
+	InFlight int                   `json:"in_flight"`
+	Queued   int                   `json:"queued"`
+	Classes  map[string]ClassStats `json:"classes"`
+}
+
+type waiter struct {
+	class *classQueue
+	elem  *list.Element
+	ready chan struct{}
+	err   error
+}
+
+type classQueue struct {
+	Class
+	pass     float64
+	waiters  *list.List
+	inFlight int
+	admitted uint64
+	shed     uint64
+}
+
+// Scheduler admits requests up to a fixed concurrency and queues the rest,
+// serving the queues with weighted fair queuing (stride scheduling).
+// When the queue is full, waiters of the lowest weighted class are shed first.
+type Scheduler struct {
+	mu       sync.Mutex
+	capacity int
+	maxQueue int
+	timeout  time.Duration
+	inFlight int
+	queued   int
+	vtime    float64
+	classes  map[string]*classQueue
+	ordered  []*classQueue
+}
+
+// NewScheduler creates a scheduler for the given classes
+func NewScheduler(capacity, maxQueue int, timeout time.Duration, classes []Class) *Scheduler {
+	s := &Scheduler{
+		capacity: capacity,
+		maxQueue: maxQueue,
+		timeout:  timeout,
+		classes:  make(map[string]*classQueue, len(classes)),
+	}
+
+	for _, class := range classes {
+		if class.Weight <= 0 {
+			class.Weight = 1
+		}
+
+		cq := &classQueue{Class: class, waiters: list.New()}
+		s.classes[class.Name] = cq
+		s.ordered = append(s.ordered, cq)
+	}
+
+	// Keep the lowest weight first so shedding can pick its victim quickly
+	sort.SliceStable(s.ordered, func(i, j int) bool {
+		return s.ordered[i].Weight < s.ordered[j].Weight
+	})
+
+	return s
+}
+
+// Acquire blocks until the request is admitted, shed or times out.
+// On success the returned function must be called once the request is done.
+func (s *Scheduler) Acquire(ctx context.Context, className string) (func(), error) {
+	s.mu.Lock()
+
+	cq, ok := s.classes[className]
+	if !ok {
+		s.mu.Unlock()
+		return nil, errors.New("unknown priority class: " + className)
+	}
+
+	// Fast path: free capacity and nobody is waiting
+	if s.inFlight < s.capacity && s.queued == 0 {
+		s.admit(cq)
+		s.mu.Unlock()
+		return s.releaseFunc(cq), nil
+	}
+
+	if s.queued >= s.maxQueue {
+		victim := s.lowestWaitingBelow(cq.Weight)
+		if victim == nil {
+			cq.shed++
+			s.mu.Unlock()
+			return nil, ErrShed
+		}
+
+		s.shedNewest(victim)
+	}
+
+	w := &waiter{class: cq, ready: make(chan struct{})}
+	if cq.waiters.Len() == 0 && cq.pass < s.vtime {
+		// A class that was idle must not accumulate credit
+		cq.pass = s.vtime
+	}
+	w.elem = cq.waiters.PushBack(w)
+	s.queued++
+	s.mu.Unlock()
+
+	var timeout <-chan time.Time
+	if s.timeout > 0 {
+		timer := time.NewTimer(s.timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	var waitErr error
+	select {
+	case <-w.ready:
+	case <-timeout:
+		waitErr = ErrQueueTimeout
+	case <-ctx.Done():
+		waitErr = ctx.Err()
+	}
+
+	if waitErr != nil {
+		s.mu.Lock()
+		if w.elem != nil {
+			cq.waiters.Remove(w.elem)
+			w.elem = nil
+			s.queued--
+			cq.shed++
+			s.mu.Unlock()
+			return nil, waitErr
+		}
+		s.mu.Unlock()
+
+		// The waiter was resolved concurrently, fall through to its outcome
+		<-w.ready
+	}
+
+	if w.err != nil {
+		return nil, w.err
+	}
+
+	return s.releaseFunc(cq), nil
+}
+
+// Stats returns a snapshot of the scheduler counters
+func (s *Scheduler) Stats() Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats := Stats{
+		Capacity: s.capacity,
+		InFlight: s.inFlight,
+		Queued:   s.queued,
+		Classes:  make(map[string]ClassStats, len(s.classes)),
+	}
+
+	for name, cq := range s.classes {
+		stats.Classes[name] = ClassStats{
+			Weight:   cq.Weight,
+			Queued:   cq.waiters.Len(),
+			InFlight: cq.inFlight,
+			Admitted: cq.admitted,
+			Shed:     cq.shed,
+		}
+	}
+
+	return stats
+}
+
+func (s *Scheduler) releaseFunc(cq *classQueue) func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+
+			s.inFlight--
+			cq.inFlight--
+			s.dispatch()
+		})
+	}
+}
+
+// admit must be called with the lock held
+func (s *Scheduler) admit(cq *classQueue) {
+	s.inFlight++
+	cq.inFlight++
+	cq.admitted++
+}
+
+// dispatch hands free slots to the waiting class with the smallest pass value.
+// It must be called with the lock held.
+func (s *Scheduler) dispatch() {
+	for s.inFlight < s.capacity && s.queued > 0 {
+		var next *classQueue
+		for _, cq := range s.ordered {
+			if cq.waiters.Len() == 0 {
+				continue
+			}
+			if next == nil || cq.pass < next.pass {
+				next = cq
+			}
+		}
+
+		if next == nil {
+			return
+		}
+
+		w := next.waiters.Remove(next.waiters.Front()).(*waiter)
+		w.elem = nil
+		s.queued--
+
+		s.vtime = next.pass
+		next.pass += 1 / float64(next.Weight)
+
+		s.admit(next)
+		close(w.ready)
+	}
+}
+
+// lowestWaitingBelow returns the lowest weighted class that has waiters and a
+// weight strictly below the given one. It must be called with the lock held.
+func (s *Scheduler) lowestWaitingBelow(weight int) *classQueue {
+	for _, cq := range s.ordered {
+		if cq.Weight >= weight {
+			return nil
+		}
+		if cq.waiters.Len() > 0 {
+			return cq
+		}
+	}
+
+	return nil
+}
+
+// shedNewest drops the most recently queued waiter of the class.
+// It must be called with the lock held.
+func (s *Scheduler) shedNewest(cq *classQueue) {
+	w := cq.waiters.Remove(cq.waiters.Back()).(*waiter)
+	w.elem = nil
+	w.err = ErrShed
+	s.queued--
+	cq.shed++
+	close(w.ready)
+}
@@ -0,0 +1,143 @@
+package recording
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// MAX_LINE_SIZE bounds a recorded exchange when reading a recording
+const MAX_LINE_SIZE = 64 << 20
+
+// Message is the headers and body of a recorded request or response. Bodies that are
+// not valid UTF-8 are kept in base64. BodyMasked marks bodies with values masked when
+// recording, which differ from the real traffic.
+type Message struct {
+	Headers    http.Header `json:"headers"`
+	Body       string      `json:"body,omitempty"`
+	BodyBase64 string      `json:"body_base64,omitempty"`
+	Truncated  bool        `json:"truncated,omitempty"`
+	BodyMasked bool        `json:"body_masked,omitempty"`
+}
+
+// SetBody stores the body as text, or base64 if it's binary
+func (m *Message) SetBody(body []byte) {
+	if utf8.Valid(body) {
+		m.Body, m.BodyBase64 = string(body), ""
+	} else {
+		m.Body, m.BodyBase64 = "", base64.StdEncoding.EncodeToString(body)
+	}
+}
+
+// BodyBytes returns the recorded body
+func (m *Message) BodyBytes() ([]byte, error) {
+	if m.BodyBase64 != "" {
+		return base64.StdEncoding.DecodeString(m.BodyBase64)
+	}
+
+	return []byte(m.Body), nil
+}
+
+// Exchange is a request proxied to an upstream service and its response. URL is the
+// request URI received by the gateway, including the service prefix.
+type Exchange struct {
+	Time     time.Time `json:"time"`
+	Service  string    `json:"service"`
+	Method   string    `json:"method"`
+	URL      string    `json:"url"`
+	Request  Message   `json:"request"`
+	Status   int       `json:"status"`
+	Response Message   `json:"response"`
+	Duration float64   `json:"duration_ms"`
+}
+
+// Recorder appends exchanges as JSON lines
+type Recorder struct {
+	mu sync.Mutex
+	w  io.WriteCloser
+}
+
+func NewRecorder(w io.WriteCloser) *Recorder {
+	return &Recorder{w: w}
+}
+
+// Record appends the exchange to the recording
+func (r *Recorder) Record(exchange *Exchange) error {
+	line, err := json.Marshal(exchange)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	_, err = r.w.Write(append(line, '\n'))
+	return err
+}
+
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.w.Close()
+}
+
+// Read decodes the exchanges of a recording
+func Read(r io.Reader) ([]*Exchange, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64<<10), MAX_LINE_SIZE)
+
+	var exchanges []*Exchange
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+
+		var exchange Exchange
+		if err := json.Unmarshal(scanner.Bytes(), &exchange); err != nil {
+			return nil, fmt.Errorf("invalid exchange on line %d: %w", line, err)
+		}
+		exchanges = append(exchanges, &exchange)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read recording: %w", err)
+	}
+
+	return exchanges, nil
+}
+
+// PeekBody returns up to maxSize bytes of body and a body reading the full content again
+func PeekBody(body io.ReadCloser, maxSize int64) ([]byte, bool, io.ReadCloser, error) {
+	if body == nil || body == http.NoBody {
+		return nil, false, body, nil
+	}
+
+	peeked, err := io.ReadAll(io.LimitReader(body, maxSize+1))
+	if err != nil {
+		return nil, false, body, err
+	}
+
+	rest := readCloser{
+		Reader: io.MultiReader(bytes.NewReader(peeked), body),
+		Closer: body,
+	}
+
+	if int64(len(peeked)) > maxSize {
+		return peeked[:maxSize], true, rest, nil
+	}
+
+	return peeked, false, rest, nil
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
@@ -0,0 +1,393 @@
+package recording
+
+import (
+	"api-gateway-service-ms/internal/pkg/logger"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MAX_BODY_DIFFS bounds the differing JSON paths reported per exchange
+const MAX_BODY_DIFFS = 10
+
+// skippedHeaders are not replayed: the client sets its own hop-by-hop headers, and
+// replayed requests get a new request ID
+var skippedHeaders = []string{
+	"Connection", "Keep-Alive", "Proxy-Connection", "Te", "Trailer", "Transfer-Encoding",
+	"Upgrade", "Content-Length", "Accept-Encoding", "X-Request-Id",
+}
+
+// ReplayOptions controls how recorded requests are sent to the target
+type ReplayOptions struct {
+	// Target is the base URL of a gateway or backend
+	Target *url.URL
+	// StripService removes the service prefix from the path, to replay against a backend
+	StripService bool
+	// Services only replays the exchanges of these services, all when empty
+	Services []string
+	// Rate is the maximum number of requests per second, unlimited when 0
+	Rate        float64
+	Concurrency int
+	// Headers are set on every request; an empty value removes the header
+	Headers http.Header
+	// IgnoreFields are dot separated JSON paths left out of body comparisons, e.g. "meta.timestamp"
+	IgnoreFields []string
+	Client       *http.Client
+}
+
+// Result is the outcome of a replayed exchange. NotReplayable is the reason the request
+// wasn't sent, when the recording can't reproduce it.
+type Result struct {
+	Exchange      *Exchange
+	Status        int
+	Err           error
+	NotReplayable string
+	BodyDiffs     []string
+	Duration      time.Duration
+}
+
+// Matches reports whether the replay returned the recorded status and body
+func (r *Result) Matches() bool {
+	return r.Err == nil && r.NotReplayable == "" && r.Status == r.Exchange.Status && len(r.BodyDiffs) == 0
+}
+
+// Report summarizes a replay
+type Report struct {
+	Total            int
+	StatusMismatches int
+	BodyMismatches   int
+	Errors           int
+	NotReplayable    int
+	Results          []*Result
+}
+
+// Replay sends the recorded requests to the target and compares the responses with
+// the recorded ones. Results are in recording order.
+func Replay(ctx context.Context, exchanges []*Exchange, opts ReplayOptions) *Report {
+	exchanges = filterServices(exchanges, opts.Services)
+	results := make([]*Result, len(exchanges))
+
+	concurrency := max(opts.Concurrency, 1)
+	client := opts.Client
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+
+	var ticker *time.Ticker
+	if opts.Rate > 0 {
+		ticker = time.NewTicker(time.Duration(float64(time.Second) / opts.Rate))
+		defer ticker.Stop()
+	}
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for range concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i] = replayOne(ctx, client, exchanges[i], opts)
+			}
+		}()
+	}
+
+send:
+	for i := range exchanges {
+		if ticker != nil {
+			select {
+			case <-ctx.Done():
+				break send
+			case <-ticker.C:
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			break send
+		case jobs <- i:
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	report := &Report{}
+	for _, result := range results {
+		if result == nil {
+			// Not sent before the context was canceled
+			continue
+		}
+
+		report.Total++
+		report.Results = append(report.Results, result)
+		switch {
+		case result.Err != nil:
+			report.Errors++
+		case result.NotReplayable != "":
+			report.NotReplayable++
+		case result.Status != result.Exchange.Status:
+			report.StatusMismatches++
+		case len(result.BodyDiffs) > 0:
+			report.BodyMismatches++
+		}
+	}
+
+	return report
+}
+
+func filterServices(exchanges []*Exchange, services []string) []*Exchange {
+	if len(services) == 0 {
+		return exchanges
+	}
+
+	filtered := make([]*Exchange, 0, len(exchanges))
+	for _, exchange := range exchanges {
+		for _, service := range services {
+			if exchange.Service == service {
+				filtered = append(filtered, exchange)
+				break
+			}
+		}
+	}
+
+	return filtered
+}
+
+func replayOne(ctx context.Context, client *http.Client, exchange *Exchange, opts ReplayOptions) *Result {
+	result := &Result{Exchange: exchange}
+
+	if reason := notReplayable(exchange, opts); reason != "" {
+		result.NotReplayable = reason
+		return result
+	}
+
+	req, err := newRequest(ctx, exchange, opts)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	result.Duration = time.Since(start)
+	if err != nil {
+		result.Err = fmt.Errorf("failed to read response: %w", err)
+		return result
+	}
+
+	result.Status = resp.StatusCode
+	if !exchange.Response.Truncated {
+		recorded, err := exchange.Response.BodyBytes()
+		if err != nil {
+			result.Err = fmt.Errorf("invalid recorded response body: %w", err)
+			return result
+		}
+		result.BodyDiffs = diffBodies(recorded, body, opts.IgnoreFields)
+	}
+
+	return result
+}
+
+// notReplayable returns why the recorded request would differ from the real one: values
+// masked in its body, or masked headers that the replay doesn't set again or remove
+func notReplayable(exchange *Exchange, opts ReplayOptions) string {
+	if exchange.Request.BodyMasked {
+		return "request body masked when recorded"
+	}
+
+	var masked []string
+	for name, values := range exchange.Request.Headers {
+		key := http.CanonicalHeaderKey(name)
+		if _, set := opts.Headers[key]; set || slices.Contains(skippedHeaders, key) {
+			continue
+		}
+		for _, value := range values {
+			if strings.Contains(value, logger.REDACTED) {
+				masked = append(masked, name)
+				break
+			}
+		}
+	}
+	if len(masked) > 0 {
+		sort.Strings(masked)
+		return "masked headers not set with --header: " + strings.Join(masked, ", ")
+	}
+
+	return ""
+}
+
+func newRequest(ctx context.Context, exchange *Exchange, opts ReplayOptions) (*http.Request, error) {
+	recorded, err := url.Parse(exchange.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid recorded URL: %w", err)
+	}
+
+	path := recorded.Path
+	if opts.StripService {
+		path = strings.TrimPrefix(path, "/"+exchange.Service)
+		if path == "" {
+			path = "/"
+		}
+	}
+
+	target := *opts.Target
+	target.Path = strings.TrimSuffix(target.Path, "/") + path
+	target.RawQuery = recorded.RawQuery
+
+	if exchange.Request.Truncated {
+		return nil, fmt.Errorf("recorded request body is truncated")
+	}
+
+	body, err := exchange.Request.BodyBytes()
+	if err != nil {
+		return nil, fmt.Errorf("invalid recorded request body: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, exchange.Method, target.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	for name, values := range exchange.Request.Headers {
+		for _, value := range values {
+			req.Header.Add(name, value)
+		}
+	}
+	for _, name := range skippedHeaders {
+		req.Header.Del(name)
+	}
+
+	for name, values := range opts.Headers {
+		req.Header.Del(name)
+		for _, value := range values {
+			if value != "" {
+				req.Header.Add(name, value)
+			}
+		}
+	}
+
+	if host := req.Header.Get("Host"); host != "" {
+		req.Host = host
+		req.Header.Del("Host")
+	}
+
+	return req, nil
+}
+
+// diffBodies returns the differences between the recorded and replayed bodies, by JSON
+// path when both are JSON. Values redacted in the recording match any value.
+func diffBodies(recorded, replayed []byte, ignoreFields []string) []string {
+	var a, b interface{}
+	if json.Unmarshal(recorded, &a) != nil || json.Unmarshal(replayed, &b) != nil {
+		if bytes.Equal(recorded, replayed) {
+			return nil
+		}
+		return []string{fmt.Sprintf("body differs: %d bytes recorded, %d bytes replayed", len(recorded), len(replayed))}
+	}
+
+	for _, field := range ignoreFields {
+		path := strings.Split(field, ".")
+		removePath(a, path)
+		removePath(b, path)
+	}
+
+	var diffs []string
+	diffJSON("", a, b, &diffs)
+	return diffs
+}
+
+func removePath(value interface{}, path []string) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if len(path) == 1 {
+			delete(v, path[0])
+		} else if child, ok := v[path[0]]; ok {
+			removePath(child, path[1:])
+		}
+	case []interface{}:
+		for _, child := range v {
+			removePath(child, path)
+		}
+	}
+}
+
+func diffJSON(path string, a, b interface{}, diffs *[]string) {
+	if len(*diffs) >= MAX_BODY_DIFFS || a == logger.REDACTED || reflect.DeepEqual(a, b) {
+		return
+	}
+
+	am, aok := a.(map[string]interface{})
+	bm, bok := b.(map[string]interface{})
+	if aok && bok {
+		keys := make(map[string]bool)
+		for key := range am {
+			keys[key] = true
+		}
+		for key := range bm {
+			keys[key] = true
+		}
+
+		sorted := make([]string, 0, len(keys))
+		for key := range keys {
+			sorted = append(sorted, key)
+		}
+		sort.Strings(sorted)
+
+		for _, key := range sorted {
+			diffJSON(joinPath(path, key), am[key], bm[key], diffs)
+		}
+		return
+	}
+
+	as, aok := a.([]interface{})
+	bs, bok := b.([]interface{})
+	if aok && bok && len(as) == len(bs) {
+		for i := range as {
+			diffJSON(fmt.Sprintf("%s[%d]", path, i), as[i], bs[i], diffs)
+		}
+		return
+	}
+
+	if path == "" {
+		path = "."
+	}
+	*diffs = append(*diffs, fmt.Sprintf("%s: %s -> %s", path, compact(a), compact(b)))
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+
+	return path + "." + key
+}
+
+// compact renders a JSON value for the report, shortening long values
+func compact(value interface{}) string {
+	if value == nil {
+		return "(missing)"
+	}
+
+	out, _ := json.Marshal(value)
+	if len(out) > 80 {
+		return string(out[:77]) + "..."
+	}
+
+	return string(out)
+}
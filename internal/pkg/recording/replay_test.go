@@ -0,0 +1,68 @@
+package recording
+
+import (
+	"api-gateway-service-ms/internal/pkg/logger"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestReplaySkipsMaskedRequests(t *testing.T) {
+	var sent atomic.Int64
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sent.Add(1)
+		io.WriteString(w, `{"ok":true}`)
+	}))
+	defer target.Close()
+
+	exchange := func(header http.Header, body string, bodyMasked bool) *Exchange {
+		return &Exchange{
+			Service:  "svc",
+			Method:   http.MethodPost,
+			URL:      "/svc/orders",
+			Request:  Message{Headers: header, Body: body, BodyMasked: bodyMasked},
+			Status:   http.StatusOK,
+			Response: Message{Body: `{"ok":true}`},
+		}
+	}
+	masked := http.Header{"Authorization": {logger.REDACTED}}
+
+	tests := []struct {
+		name     string
+		exchange *Exchange
+		headers  http.Header
+		want     string
+	}{
+		{"plain request", exchange(http.Header{}, `{"amount":42}`, false), nil, ""},
+		{"masked body", exchange(http.Header{}, `{"email":"`+logger.REDACTED+`"}`, true), nil, "request body masked"},
+		{"masked header", exchange(masked, "", false), nil, "Authorization"},
+		{"masked header set again", exchange(masked, "", false), http.Header{"Authorization": {"Bearer token"}}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := sent.Load()
+
+			targetURL, _ := url.Parse(target.URL)
+			report := Replay(context.Background(), []*Exchange{tt.exchange}, ReplayOptions{Target: targetURL, Headers: tt.headers})
+			result := report.Results[0]
+
+			if tt.want == "" {
+				if !result.Matches() {
+					t.Fatalf("got %+v, want a matching replay", result)
+				}
+				return
+			}
+			if !strings.Contains(result.NotReplayable, tt.want) || report.NotReplayable != 1 {
+				t.Fatalf("got not replayable %q, want %q", result.NotReplayable, tt.want)
+			}
+			if sent.Load() != before {
+				t.Fatal("request not replayable was sent")
+			}
+		})
+	}
+}
@@ -0,0 +1,187 @@
+package tracing
+
+import (
+	"api-gateway-service-ms/config"
+	"context"
+	"fmt"
+	"net/http"
+
+	"go.opentelemetry.io/contrib/propagators/b3"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	TRACER_NAME          = "api-gateway-service-ms"
+	DEFAULT_SERVICE_NAME = "api-gateway"
+
+	EXPORTER_OTLP   = "otlp"
+	EXPORTER_STDOUT = "stdout"
+
+	PROPAGATOR_TRACECONTEXT = "tracecontext"
+	PROPAGATOR_BAGGAGE      = "baggage"
+	PROPAGATOR_B3           = "b3"
+	PROPAGATOR_B3_MULTI     = "b3multi"
+)
+
+// Setup installs the propagators and, when tracing is enabled, a tracer provider
+// exporting to the configured exporter. The returned function flushes pending spans.
+func Setup(ctx context.Context, cfg config.TracingConfig) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(NewPropagator(cfg.Propagators))
+
+	// Without a provider spans are not recorded, but trace context is still propagated
+	if !cfg.Enabled {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	exporter, err := newExporter(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return SetupWithExporter(cfg, exporter), nil
+}
+
+// SetupWithExporter installs a tracer provider sending spans to exporter, e.g. an
+// in-memory exporter from go.opentelemetry.io/otel/sdk/trace/tracetest
+func SetupWithExporter(cfg config.TracingConfig, exporter sdktrace.SpanExporter) func(context.Context) error {
+	serviceName := cfg.ServiceName
+	if serviceName == "" {
+		serviceName = DEFAULT_SERVICE_NAME
+	}
+
+	ratio := 1.0
+	if cfg.SampleRatio != nil {
+		ratio = *cfg.SampleRatio
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		// Follow the sampling decision of the caller, sample new traces by ratio
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio))),
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceName(serviceName))),
+	)
+	otel.SetTracerProvider(provider)
+
+	return provider.Shutdown
+}
+
+// NewPropagator combines the named propagators, W3C trace context and baggage by default
+func NewPropagator(names []string) propagation.TextMapPropagator {
+	if len(names) == 0 {
+		names = []string{PROPAGATOR_TRACECONTEXT, PROPAGATOR_BAGGAGE}
+	}
+
+	propagators := make([]propagation.TextMapPropagator, 0, len(names))
+	for _, name := range names {
+		switch name {
+		case PROPAGATOR_TRACECONTEXT:
+			propagators = append(propagators, propagation.TraceContext{})
+		case PROPAGATOR_BAGGAGE:
+			propagators = append(propagators, propagation.Baggage{})
+		case PROPAGATOR_B3:
+			propagators = append(propagators, b3.New(b3.WithInjectEncoding(b3.B3SingleHeader)))
+		case PROPAGATOR_B3_MULTI:
+			propagators = append(propagators, b3.New(b3.WithInjectEncoding(b3.B3MultipleHeader)))
+		}
+	}
+
+	return propagation.NewCompositeTextMapPropagator(propagators...)
+}
+
+func newExporter(ctx context.Context, cfg config.TracingConfig) (sdktrace.SpanExporter, error) {
+	switch cfg.Exporter {
+	case "", EXPORTER_OTLP:
+		opts := []otlptracehttp.Option{}
+		if cfg.Endpoint != "" {
+			opts = append(opts, otlptracehttp.WithEndpoint(cfg.Endpoint))
+		}
+		if cfg.Insecure {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+		if len(cfg.Headers) > 0 {
+			opts = append(opts, otlptracehttp.WithHeaders(cfg.Headers))
+		}
+
+		exporter, err := otlptracehttp.New(ctx, opts...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create OTLP exporter: %w", err)
+		}
+		return exporter, nil
+	case EXPORTER_STDOUT:
+		return stdouttrace.New(stdouttrace.WithPrettyPrint())
+	default:
+		return nil, fmt.Errorf("unsupported tracing exporter: %s", cfg.Exporter)
+	}
+}
+
+// Start starts a span as a child of the span in ctx
+func Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	return otel.Tracer(TRACER_NAME).Start(ctx, name, opts...)
+}
+
+// EndWithError records err on the span, if any, and ends it
+func EndWithError(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// Inject writes the trace context of ctx into the request headers
+func Inject(ctx context.Context, header http.Header) {
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(header))
+}
+
+// Extract returns ctx with the trace context found in the request headers
+func Extract(ctx context.Context, header http.Header) context.Context {
+	return otel.GetTextMapPropagator().Extract(ctx, propagation.HeaderCarrier(header))
+}
+
+// Transport creates a client span for every attempt sent to an upstream service and
+// propagates the trace context to it
+func Transport(upstream string, next http.RoundTripper) http.RoundTripper {
+	return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		ctx, span := Start(req.Context(), req.Method+" "+upstream,
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(
+				semconv.HTTPRequestMethodKey.String(req.Method),
+				semconv.ServerAddress(req.URL.Hostname()),
+				semconv.URLFull(req.URL.Redacted()),
+				attribute.String("gateway.upstream", upstream),
+			),
+		)
+
+		req = req.WithContext(ctx)
+		Inject(ctx, req.Header)
+
+		resp, err := next.RoundTrip(req)
+		if err != nil {
+			EndWithError(span, err)
+			return nil, err
+		}
+
+		span.SetAttributes(semconv.HTTPResponseStatusCode(resp.StatusCode))
+		if resp.StatusCode >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(resp.StatusCode))
+		}
+		span.End()
+
+		return resp, nil
+	})
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
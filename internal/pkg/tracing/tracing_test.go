@@ -0,0 +1,146 @@
+package tracing
+
+import (
+	"api-gateway-service-ms/config"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// memoryExporter keeps the spans after the tracer provider is shut down
+type memoryExporter struct {
+	*tracetest.InMemoryExporter
+}
+
+func (e memoryExporter) Shutdown(context.Context) error {
+	return nil
+}
+
+// setupTest installs a tracer provider exporting to memory, and returns a function
+// flushing the spans ended so far
+func setupTest(t *testing.T, cfg config.TracingConfig) func() tracetest.SpanStubs {
+	t.Helper()
+
+	exporter := memoryExporter{tracetest.NewInMemoryExporter()}
+	if _, err := Setup(context.Background(), config.TracingConfig{Propagators: cfg.Propagators}); err != nil {
+		t.Fatal(err)
+	}
+	shutdown := SetupWithExporter(cfg, exporter)
+
+	return func() tracetest.SpanStubs {
+		if err := shutdown(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		return exporter.GetSpans()
+	}
+}
+
+func TestTransportCreatesClientSpans(t *testing.T) {
+	flush := setupTest(t, config.TracingConfig{})
+
+	var traceparent string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("Traceparent")
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer upstream.Close()
+
+	client := &http.Client{Transport: Transport("user", http.DefaultTransport)}
+	ctx, parent := Start(context.Background(), "GET /user/*path", trace.WithSpanKind(trace.SpanKindServer))
+
+	for _, path := range []string{"/ok", "/fail"} {
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, upstream.URL+path, nil)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+	parent.End()
+
+	spans := flush()
+	if len(spans) != 3 {
+		t.Fatalf("got %d spans, want 2 client spans and their parent", len(spans))
+	}
+
+	ok, fail, server := spans[0], spans[1], spans[2]
+	for _, span := range []tracetest.SpanStub{ok, fail} {
+		if span.Name != "GET user" || span.SpanKind != trace.SpanKindClient {
+			t.Errorf("got span %q of kind %v, want client span GET user", span.Name, span.SpanKind)
+		}
+		if span.Parent.SpanID() != server.SpanContext.SpanID() || span.SpanContext.TraceID() != server.SpanContext.TraceID() {
+			t.Errorf("span %q is not a child of the server span", span.Name)
+		}
+		if !hasAttribute(span.Attributes, attribute.String("gateway.upstream", "user")) {
+			t.Errorf("span %q has no gateway.upstream attribute: %v", span.Name, span.Attributes)
+		}
+	}
+
+	if ok.Status.Code == codes.Error || fail.Status.Code != codes.Error {
+		t.Errorf("got statuses %v and %v, want only the 502 failed", ok.Status.Code, fail.Status.Code)
+	}
+
+	// The upstream continues the trace from the client span
+	if want := "00-" + fail.SpanContext.TraceID().String() + "-" + fail.SpanContext.SpanID().String() + "-01"; traceparent != want {
+		t.Errorf("got traceparent %q, want %q", traceparent, want)
+	}
+}
+
+func TestExtractB3(t *testing.T) {
+	flush := setupTest(t, config.TracingConfig{Propagators: []string{PROPAGATOR_B3_MULTI}})
+
+	header := http.Header{}
+	header.Set("X-B3-TraceId", "4bf92f3577b34da6a3ce929d0e0e4736")
+	header.Set("X-B3-SpanId", "00f067aa0ba902b7")
+	header.Set("X-B3-Sampled", "1")
+
+	_, span := Start(Extract(context.Background(), header), "GET /user/*path")
+	span.End()
+
+	spans := flush()
+	if len(spans) != 1 {
+		t.Fatalf("got %d spans, want 1", len(spans))
+	}
+	if traceID := spans[0].SpanContext.TraceID().String(); traceID != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("got trace ID %s, want the caller's", traceID)
+	}
+	if parentID := spans[0].Parent.SpanID().String(); parentID != "00f067aa0ba902b7" {
+		t.Errorf("got parent span ID %s, want the caller's", parentID)
+	}
+}
+
+func TestSampleRatioFollowsCaller(t *testing.T) {
+	ratio := 0.0
+	flush := setupTest(t, config.TracingConfig{SampleRatio: &ratio})
+
+	_, unsampled := Start(context.Background(), "new trace")
+	unsampled.End()
+
+	header := http.Header{}
+	header.Set("Traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	_, sampled := Start(Extract(context.Background(), header), "sampled by the caller")
+	sampled.End()
+
+	spans := flush()
+	if len(spans) != 1 || spans[0].Name != "sampled by the caller" {
+		t.Fatalf("got spans %v, want only the trace sampled by the caller", spans)
+	}
+}
+
+func hasAttribute(attributes []attribute.KeyValue, want attribute.KeyValue) bool {
+	for _, kv := range attributes {
+		if kv == want {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,170 @@
+package proxy
+
+import (
+	"api-gateway-service-ms/internal/pkg/httpcache"
+	"bytes"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// sharedResponse is an upstream response recorded once and replayed to every waiter
+type sharedResponse struct {
+	statusCode int
+	header     http.Header
+	body       []byte
+	// streamed responses were too large or flushed, and already sent to the first caller only
+	streamed bool
+}
+
+// shareable reports whether the response may be replayed to other callers. Responses
+// marked private or no-store, or setting cookies, belong to the first caller only.
+func (sr *sharedResponse) shareable() bool {
+	if sr.streamed || sr.header.Get("Set-Cookie") != "" {
+		return false
+	}
+
+	directives := httpcache.ParseCacheControl(sr.header)
+	return !directives.Has("private") && !directives.Has("no-store")
+}
+
+// writeTo copies the response to w, keeping headers already set on w
+func (sr *sharedResponse) writeTo(w http.ResponseWriter) {
+	header := w.Header()
+	for k, v := range sr.header {
+		header[k] = v
+	}
+
+	w.WriteHeader(sr.statusCode)
+	w.Write(sr.body)
+}
+
+// sharedRecorder records the upstream response of the first caller up to maxSize bytes.
+// Larger or flushed responses are passed through to the first caller's writer instead.
+type sharedRecorder struct {
+	w           http.ResponseWriter
+	header      http.Header
+	statusCode  int
+	body        bytes.Buffer
+	maxSize     int64
+	passthrough bool
+}
+
+func newSharedRecorder(w http.ResponseWriter, maxSize int64) *sharedRecorder {
+	return &sharedRecorder{
+		w:       w,
+		header:  make(http.Header),
+		maxSize: maxSize,
+	}
+}
+
+func (r *sharedRecorder) Header() http.Header {
+	if r.passthrough {
+		return r.w.Header()
+	}
+
+	return r.header
+}
+
+func (r *sharedRecorder) WriteHeader(code int) {
+	if r.statusCode == 0 {
+		r.statusCode = code
+	}
+}
+
+func (r *sharedRecorder) Write(b []byte) (int, error) {
+	r.WriteHeader(http.StatusOK)
+	if !r.passthrough && int64(r.body.Len()+len(b)) > r.maxSize {
+		r.passThrough()
+	}
+	if r.passthrough {
+		return r.w.Write(b)
+	}
+
+	return r.body.Write(b)
+}
+
+// Flush passes a streamed response through, e.g. server-sent events
+func (r *sharedRecorder) Flush() {
+	r.passThrough()
+	if flusher, ok := r.w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// passThrough sends what was recorded, then writes straight to the first caller's writer
+func (r *sharedRecorder) passThrough() {
+	if r.passthrough {
+		return
+	}
+
+	r.passthrough = true
+	r.response().writeTo(r.w)
+	r.body.Reset()
+}
+
+func (r *sharedRecorder) response() *sharedResponse {
+	statusCode := r.statusCode
+	if statusCode == 0 {
+		statusCode = http.StatusOK
+	}
+
+	return &sharedResponse{
+		statusCode: statusCode,
+		header:     r.header.Clone(),
+		body:       bytes.Clone(r.body.Bytes()),
+		streamed:   r.passthrough,
+	}
+}
+
+type inflightCall struct {
+	done     chan struct{}
+	response *sharedResponse
+}
+
+// Coalescer collapses concurrent identical requests into a single upstream call,
+// in the spirit of golang.org/x/sync/singleflight but with a bounded wait
+type Coalescer struct {
+	mu    sync.Mutex
+	calls map[string]*inflightCall
+}
+
+func NewCoalescer() *Coalescer {
+	return &Coalescer{
+		calls: make(map[string]*inflightCall),
+	}
+}
+
+// Do runs fn once for all concurrent callers using the same key. Callers that wait
+// longer than maxWait give up and get a nil response, so they can call the upstream
+// themselves. The boolean reports whether the response came from another caller.
+func (co *Coalescer) Do(key string, maxWait time.Duration, fn func() *sharedResponse) (*sharedResponse, bool) {
+	co.mu.Lock()
+	if call, ok := co.calls[key]; ok {
+		co.mu.Unlock()
+
+		timer := time.NewTimer(maxWait)
+		defer timer.Stop()
+
+		select {
+		case <-call.done:
+			return call.response, true
+		case <-timer.C:
+			return nil, false
+		}
+	}
+
+	call := &inflightCall{done: make(chan struct{})}
+	co.calls[key] = call
+	co.mu.Unlock()
+
+	defer func() {
+		co.mu.Lock()
+		delete(co.calls, key)
+		co.mu.Unlock()
+		close(call.done)
+	}()
+
+	call.response = fn()
+	return call.response, false
+}
@@ -0,0 +1,156 @@
+package proxy
+
+import (
+	"api-gateway-service-ms/config"
+	"api-gateway-service-ms/internal/pkg/logger"
+	"api-gateway-service-ms/internal/pkg/metrics"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+const COALESCING_TEST_REQUESTS = 5
+
+// newCoalescingProxy returns a gateway proxying /svc to upstream with coalescing enabled
+func newCoalescingProxy(t *testing.T, upstream string) *gin.Engine {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	cfg := newTestConfig(t, fmt.Sprintf("coalescing:\n  enabled: true\n  max_body_size: 64\n"+
+		"forward_service_url:\n  svc: %s\n", upstream))
+
+	log := logger.New(logger.LoggerConfig{Level: logrus.ErrorLevel, Output: io.Discard})
+	router := gin.New()
+	NewServiceProxy(cfg, log, metrics.New()).SetupRoutes(router)
+
+	return router
+}
+
+// newTestConfig loads the settings after a minimal valid configuration
+func newTestConfig(t *testing.T, settings string) *config.Manager {
+	t.Helper()
+
+	settings = "server:\n  port: \"8080\"\ncache:\n  driver: memory\nauth:\n  jwt_secret: secret\n" +
+		"ratelimit:\n  limit: 100\n  period: 1m\n" + settings
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, config.CONFIG_FILE), []byte(settings), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := config.NewManager(dir)
+	if err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+
+	return cfg
+}
+
+// sendConcurrently sends the same GET from several clients at once
+func sendConcurrently(router http.Handler, header http.Header) []*httptest.ResponseRecorder {
+	recorders := make([]*httptest.ResponseRecorder, COALESCING_TEST_REQUESTS)
+	start := make(chan struct{})
+
+	var wg sync.WaitGroup
+	for i := range recorders {
+		recorders[i] = httptest.NewRecorder()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// Server requests have a cancelable context, which the reverse proxy relies on
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			req := httptest.NewRequestWithContext(ctx, http.MethodGet, "/svc/items", nil)
+			req.Header = header.Clone()
+			<-start
+			router.ServeHTTP(recorders[i], req)
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	return recorders
+}
+
+func TestCoalescing(t *testing.T) {
+	tests := []struct {
+		name     string
+		header   http.Header
+		response http.Header
+		body     string
+		hits     int64
+	}{
+		{name: "anonymous", body: "items", hits: 1},
+		{name: "authorization", header: http.Header{"Authorization": {"Bearer token"}}, body: "items", hits: COALESCING_TEST_REQUESTS},
+		{name: "cookie", header: http.Header{"Cookie": {"sid=1"}}, body: "items", hits: COALESCING_TEST_REQUESTS},
+		{name: "set-cookie", response: http.Header{"Set-Cookie": {"sid=1"}}, body: "items", hits: COALESCING_TEST_REQUESTS},
+		{name: "private", response: http.Header{"Cache-Control": {"private"}}, body: "items", hits: COALESCING_TEST_REQUESTS},
+		{name: "no-store", response: http.Header{"Cache-Control": {"no-store"}}, body: "items", hits: COALESCING_TEST_REQUESTS},
+		{name: "larger than max_body_size", body: strings.Repeat("x", 100), hits: COALESCING_TEST_REQUESTS},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var hits atomic.Int64
+			upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				hits.Add(1)
+				// Keep the first call in flight while the others arrive
+				time.Sleep(200 * time.Millisecond)
+				for k, v := range tt.response {
+					w.Header()[k] = v
+				}
+				io.WriteString(w, tt.body)
+			}))
+			defer upstream.Close()
+
+			header := tt.header
+			if header == nil {
+				header = http.Header{}
+			}
+
+			for _, recorder := range sendConcurrently(newCoalescingProxy(t, upstream.URL), header) {
+				if recorder.Code != http.StatusOK || recorder.Body.String() != tt.body {
+					t.Fatalf("got %d %q, want 200 %q", recorder.Code, recorder.Body.String(), tt.body)
+				}
+			}
+
+			if got := hits.Load(); got != tt.hits {
+				t.Fatalf("upstream hit %d times, want %d", got, tt.hits)
+			}
+		})
+	}
+}
+
+func TestSharedRecorderStreamsFlushedResponses(t *testing.T) {
+	w := httptest.NewRecorder()
+	recorder := newSharedRecorder(w, 1024)
+
+	recorder.Header().Set("Content-Type", "text/event-stream")
+	recorder.WriteHeader(http.StatusOK)
+	io.WriteString(recorder, "data: 1\n\n")
+	recorder.Flush()
+
+	if !w.Flushed || w.Body.String() != "data: 1\n\n" || w.Header().Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("got flushed %v, body %q and header %v, want the event sent", w.Flushed, w.Body.String(), w.Header())
+	}
+
+	io.WriteString(recorder, "data: 2\n\n")
+	if w.Body.String() != "data: 1\n\ndata: 2\n\n" {
+		t.Fatalf("got body %q, want both events", w.Body.String())
+	}
+
+	if response := recorder.response(); response.shareable() {
+		t.Fatal("streamed response is shareable")
+	}
+}
@@ -2,29 +2,54 @@ package proxy
 
 import (
 	"api-gateway-service-ms/config"
+	"api-gateway-service-ms/internal/pkg/accesslog"
+	"api-gateway-service-ms/internal/pkg/httpcache"
 	"api-gateway-service-ms/internal/pkg/logger"
+	"api-gateway-service-ms/internal/pkg/metrics"
+	"api-gateway-service-ms/internal/pkg/recording"
 	"api-gateway-service-ms/internal/pkg/response"
+	"api-gateway-service-ms/internal/pkg/tracing"
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"io"
+	"math/rand/v2"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
+	"reflect"
+	"slices"
 	"strings"
+	"sync"
 	"time"
+	"unicode/utf8"
 
 	"github.com/gin-gonic/gin"
 )
 
+const (
+	X_COALESCED         = "X-Coalesced"
+	COALESCING_MAX_WAIT = 5 * time.Second
+	// COALESCING_MAX_BODY_SIZE bounds the response held for the callers sharing it
+	COALESCING_MAX_BODY_SIZE = 1 << 20
+
+	RECORDING_MAX_BODY_SIZE = 1 << 20
+)
+
 // ServiceProxy handles proxying requests to backend services
 type ServiceProxy struct {
-	config     *config.Config
+	config     *config.Manager
 	httpClient *http.Client
 	logger     *logger.Logger
+	metrics    *metrics.Metrics
+	coalescer  *Coalescer
+	// recorder is opened on the first recorded exchange, nil if the file couldn't be opened
+	recorder     *recording.Recorder
+	recorderOnce sync.Once
 }
 
 // NewServiceProxy creates a new service proxy
-func NewServiceProxy(cfg *config.Config, logger *logger.Logger) *ServiceProxy {
+func NewServiceProxy(cfg *config.Manager, logger *logger.Logger, metrics *metrics.Metrics) *ServiceProxy {
 	httpClient := &http.Client{
 		Timeout: 30 * time.Second,
 		Transport: &http.Transport{
@@ -34,17 +59,30 @@ func NewServiceProxy(cfg *config.Config, logger *logger.Logger) *ServiceProxy {
 		},
 	}
 
-	return &ServiceProxy{
+	sp := &ServiceProxy{
 		config:     cfg,
 		httpClient: httpClient,
 		logger:     logger,
+		metrics:    metrics,
+		coalescer:  NewCoalescer(),
 	}
+
+	// Drop pooled connections to upstreams that may have been removed or moved
+	cfg.Subscribe(func(old, new *config.Config) {
+		if !reflect.DeepEqual(old.FowardServiceUrl, new.FowardServiceUrl) {
+			httpClient.CloseIdleConnections()
+			sp.logger.Infof("Upstream services changed, closed idle upstream connections")
+		}
+	})
+
+	return sp
 }
 
 // ForwardRequest forwards a request to a backend service
 func (sp *ServiceProxy) ForwardRequest(serviceName string) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		serviceURL, exists := sp.config.FowardServiceUrl[serviceName]
+		cfg := sp.config.Get()
+		serviceURL, exists := cfg.FowardServiceUrl[serviceName]
 		if !exists || serviceURL == "" {
 			response.Error(
 				c,
@@ -71,11 +109,24 @@ func (sp *ServiceProxy) ForwardRequest(serviceName string) gin.HandlerFunc {
 
 		// Create reverse proxy
 		proxy := httputil.NewSingleHostReverseProxy(target)
+		// Each attempt gets a client span, and the upstream receives the trace context
+		proxy.Transport = tracing.Transport(serviceName, sp.metrics.Transport(serviceName, sp.httpClient.Transport))
+
+		// Record the upstream address and its latency until the response headers for the access log
+		var upstreamStart time.Time
+		upstreamDone := func() {
+			c.Set(accesslog.KeyUpstreamLatency, time.Since(upstreamStart))
+		}
+
+		// Start recording the exchange before the body is consumed by the upstream request
+		exchange := sp.startRecording(c, cfg, serviceName)
 
 		// Set custom director to modify the request
 		originalDirector := proxy.Director
 		proxy.Director = func(req *http.Request) {
 			originalDirector(req)
+			upstreamStart = time.Now()
+			c.Set(accesslog.KeyUpstreamAddr, target.Host)
 
 			// Update request URL
 			req.URL.Scheme = target.Scheme
@@ -101,25 +152,41 @@ func (sp *ServiceProxy) ForwardRequest(serviceName string) gin.HandlerFunc {
 			req.Header.Set("X-Forwarded-Host", c.Request.Host)
 			req.Header.Set("X-Forwarded-Proto", c.Request.URL.Scheme)
 
-			// Set X-Request-ID for tracing
+			// Forward the request ID assigned by the logger middleware, so the upstream logs
+			// correlate with ours. Trace context headers are set by the transport.
 			requestID := c.GetHeader("X-Request-ID")
 			if requestID == "" {
-				requestID = fmt.Sprintf("%d", time.Now().UnixNano())
+				requestID = c.GetString(logger.FieldRequestID)
+			}
+			if requestID != "" {
+				req.Header.Set("X-Request-ID", requestID)
 			}
-			req.Header.Set("X-Request-ID", requestID)
+
+			sp.logger.WithContext(req.Context()).
+				WithField("headers", req.Header).
+				Debugf("Forwarding %s %s to %s", req.Method, req.URL.Path, serviceName)
 		}
 
-		// Set custom error handler
+		// Set custom error handler, writing to rw so coalesced waiters see the error too
 		proxy.ErrorHandler = func(rw http.ResponseWriter, req *http.Request, err error) {
-			sp.logger.Errorf("Proxy error: %v", err)
-			response.Error(c, http.StatusBadGateway, "Bad gateway")
+			upstreamDone()
+			sp.logger.WithContext(req.Context()).Errorf("Proxy error: %v", err)
+			rw.Header().Set("Content-Type", "application/json; charset=utf-8")
+			rw.WriteHeader(http.StatusBadGateway)
+			json.NewEncoder(rw).Encode(response.NewResponse(http.StatusBadGateway, "Bad gateway", nil))
 			c.Abort()
 		}
 
 		// Set custom response modifier
 		proxy.ModifyResponse = func(resp *http.Response) error {
-			// Log response status
-			sp.logger.Infof("Proxied response from %s with status code: %d", serviceName, resp.StatusCode)
+			upstreamDone()
+			// Log response status, left to the sampled request log under sampling
+			entry := sp.logger.WithContext(resp.Request.Context())
+			if sp.logger.SamplingEnabled() {
+				entry.Debugf("Proxied response from %s with status code: %d", serviceName, resp.StatusCode)
+			} else {
+				entry.Infof("Proxied response from %s with status code: %d", serviceName, resp.StatusCode)
+			}
 
 			// Read and modify response body if needed
 			if resp.StatusCode >= http.StatusBadRequest {
@@ -134,14 +201,53 @@ func (sp *ServiceProxy) ForwardRequest(serviceName string) gin.HandlerFunc {
 				// Create new body with the read bytes
 				resp.Body = io.NopCloser(bytes.NewBuffer(bodyBytes))
 
-				// Log error response
-				sp.logger.Warnf("Error response from %s: %s", serviceName, string(bodyBytes))
+				// Log error response, masking sensitive data and truncating large bodies
+				body := sp.logger.Redactor().Body(resp.Header.Get("Content-Type"), bodyBytes)
+				entry.Warnf("Error response from %s: %s", serviceName, body)
+			}
+
+			if exchange != nil {
+				sp.finishRecording(exchange, resp, cfg)
 			}
 
 			return nil
 		}
 
-		// Serve the request
+		// Serve the request, sharing one upstream call between identical concurrent requests
+		if maxWait, ok := sp.coalescing(c, cfg); ok {
+			// Only requests without credentials are coalesced, so the key has no user
+			key := httpcache.PrimaryKey(c.Request, httpcache.RouteKeyOptions(
+				httpcache.MatchRoute(cfg.ResponseCache.Routes, c.Request.URL.Path),
+				c.Request,
+				"",
+			))
+
+			shared, coalesced := sp.coalescer.Do(serviceName+":"+key, maxWait, func() *sharedResponse {
+				recorder := newSharedRecorder(c.Writer, coalescingMaxBodySize(cfg))
+				proxy.ServeHTTP(recorder, c.Request)
+				return recorder.response()
+			})
+
+			switch {
+			case shared == nil:
+				sp.logger.Warnf("Gave up waiting for coalesced request %s, calling upstream directly", key)
+			case !coalesced:
+				// The first caller's response was recorded for the others, unless streamed
+				if !shared.streamed {
+					shared.writeTo(c.Writer)
+				}
+				c.Abort()
+				return
+			case shared.shareable():
+				c.Writer.Header().Set(X_COALESCED, "true")
+				shared.writeTo(c.Writer)
+				c.Abort()
+				return
+			default:
+				sp.logger.Debugf("Coalesced response for %s is not shareable, calling upstream directly", key)
+			}
+		}
+
 		proxy.ServeHTTP(c.Writer, c.Request)
 
 		// Abort Gin's request handling since the proxy has already written the response
@@ -149,16 +255,163 @@ func (sp *ServiceProxy) ForwardRequest(serviceName string) gin.HandlerFunc {
 	}
 }
 
-// SetupRoutes sets up routes for all services
-func (sp *ServiceProxy) SetupRoutes(router *gin.Engine) {
-	// Set up routes for each service
-	for service := range sp.config.FowardServiceUrl {
-		// Create route group for the service
-		group := router.Group("/" + service)
+// startRecording returns the exchange to record when the request is selected for the
+// recording, with the request headers and body masked like the logs
+func (sp *ServiceProxy) startRecording(c *gin.Context, cfg *config.Config, serviceName string) *recording.Exchange {
+	recordingConfig := cfg.Recording
+	if !recordingConfig.Enabled {
+		return nil
+	}
+
+	if len(recordingConfig.Services) > 0 && !slices.Contains(recordingConfig.Services, serviceName) {
+		return nil
+	}
+
+	if recordingConfig.SampleRate != nil && rand.Float64() >= *recordingConfig.SampleRate {
+		return nil
+	}
+
+	if sp.openRecorder(recordingConfig) == nil {
+		return nil
+	}
+
+	body, truncated, rest, err := recording.PeekBody(c.Request.Body, recordingMaxBodySize(cfg))
+	c.Request.Body = rest
+	if err != nil {
+		sp.logger.Errorf("Failed to record request body: %v", err)
+		return nil
+	}
+
+	exchange := &recording.Exchange{
+		Time:    time.Now(),
+		Service: serviceName,
+		Method:  c.Request.Method,
+		URL:     sp.logger.Redactor().String(c.Request.URL.RequestURI()),
+	}
+	sp.recordMessage(&exchange.Request, c.Request.Header, body, truncated)
+
+	return exchange
+}
+
+// openRecorder opens the recording file once, so it's only created when traffic is
+// recorded, and recording.enabled can be toggled on reload
+func (sp *ServiceProxy) openRecorder(recordingConfig config.RecordingConfig) *recording.Recorder {
+	sp.recorderOnce.Do(func() {
+		file, err := accesslog.NewRotatingFile(recordingConfig.Path, recordingConfig.MaxSize, recordingConfig.MaxBackups)
+		if err != nil {
+			sp.logger.Errorf("Traffic recording disabled until restart: %v", err)
+			return
+		}
+		sp.recorder = recording.NewRecorder(file)
+	})
+
+	return sp.recorder
+}
+
+// finishRecording adds the upstream response to the exchange and writes it
+func (sp *ServiceProxy) finishRecording(exchange *recording.Exchange, resp *http.Response, cfg *config.Config) {
+	body, truncated, rest, err := recording.PeekBody(resp.Body, recordingMaxBodySize(cfg))
+	resp.Body = rest
+	if err != nil {
+		sp.logger.Errorf("Failed to record response body: %v", err)
+		return
+	}
+
+	exchange.Status = resp.StatusCode
+	exchange.Duration = float64(time.Since(exchange.Time)) / float64(time.Millisecond)
+	sp.recordMessage(&exchange.Response, resp.Header, body, truncated)
+
+	if err := sp.recorder.Record(exchange); err != nil {
+		sp.logger.Errorf("Failed to write recorded exchange: %v", err)
+	}
+}
+
+// recordMessage masks the headers and text bodies; binary bodies are kept as they are
+func (sp *ServiceProxy) recordMessage(message *recording.Message, header http.Header, body []byte, truncated bool) {
+	redactor := sp.logger.Redactor()
+	message.Headers = redactor.Header(header)
+	message.Truncated = truncated
+
+	if utf8.Valid(body) {
+		masked := redactor.Mask(header.Get("Content-Type"), body)
+		message.SetBody([]byte(masked))
+		message.BodyMasked = strings.Count(masked, logger.REDACTED) > bytes.Count(body, []byte(logger.REDACTED))
+	} else {
+		message.SetBody(body)
+	}
+}
+
+func recordingMaxBodySize(cfg *config.Config) int64 {
+	if cfg.Recording.MaxBodySize > 0 {
+		return cfg.Recording.MaxBodySize
+	}
+
+	return RECORDING_MAX_BODY_SIZE
+}
+
+func coalescingMaxBodySize(cfg *config.Config) int64 {
+	if cfg.Coalescing.MaxBodySize > 0 {
+		return cfg.Coalescing.MaxBodySize
+	}
+
+	return COALESCING_MAX_BODY_SIZE
+}
+
+// coalescing reports whether the request may share an upstream call and how long it may wait.
+// Requests with credentials are never coalesced, so one caller's response can't reach another.
+func (sp *ServiceProxy) coalescing(c *gin.Context, appConfig *config.Config) (time.Duration, bool) {
+	if c.Request.Method != http.MethodGet || c.Request.ContentLength > 0 {
+		return 0, false
+	}
+
+	if c.GetHeader("Authorization") != "" || c.GetHeader("Cookie") != "" {
+		return 0, false
+	}
+
+	if httpcache.ParseCacheControl(c.Request.Header).Has("no-store") {
+		return 0, false
+	}
+
+	cfg := appConfig.Coalescing
+	enabled, maxWait := cfg.Enabled, cfg.MaxWait
+
+	var route *config.CoalescingRouteConfig
+	for i, r := range cfg.Routes {
+		if strings.HasPrefix(c.Request.URL.Path, r.Path) && (route == nil || len(r.Path) > len(route.Path)) {
+			route = &cfg.Routes[i]
+		}
+	}
+
+	if route != nil {
+		enabled = route.Enabled
+		if route.MaxWait > 0 {
+			maxWait = route.MaxWait
+		}
+	}
+
+	if maxWait <= 0 {
+		maxWait = COALESCING_MAX_WAIT
+	}
+
+	return maxWait, enabled
+}
+
+// HandleRequest forwards the request to the service named by the first path segment,
+// e.g. /user/profile goes to the "user" service
+func (sp *ServiceProxy) HandleRequest() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		serviceName, _, _ := strings.Cut(strings.TrimPrefix(c.Request.URL.Path, "/"), "/")
+		sp.ForwardRequest(serviceName)(c)
+	}
+}
 
-		// Add proxy handler
-		group.Any("", sp.ForwardRequest(service))
+// SetupRoutes sends every request not matched by a gateway route to the proxy, after the
+// given handlers. Services are resolved per request, so services added or removed by a
+// config reload apply at once.
+func (sp *ServiceProxy) SetupRoutes(router *gin.Engine, handlers ...gin.HandlerFunc) {
+	router.NoRoute(append(handlers, sp.HandleRequest())...)
 
+	for service := range sp.config.Get().FowardServiceUrl {
 		sp.logger.Infof("Registered routes for service: %s", service)
 	}
 }
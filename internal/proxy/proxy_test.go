@@ -0,0 +1,45 @@
+package proxy
+
+import (
+	"api-gateway-service-ms/internal/pkg/logger"
+	"api-gateway-service-ms/internal/pkg/metrics"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// The recording file is only created once an exchange is recorded, never by commands
+// that just build the router
+func TestRecordingFileCreatedOnFirstExchange(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	}))
+	defer upstream.Close()
+
+	path := filepath.Join(t.TempDir(), "traffic.jsonl")
+	cfg := newTestConfig(t, fmt.Sprintf("recording:\n  enabled: true\n  path: %s\nforward_service_url:\n  svc: %s\n", path, upstream.URL))
+
+	log := logger.New(logger.LoggerConfig{Level: logrus.ErrorLevel, Output: io.Discard})
+	router := gin.New()
+	NewServiceProxy(cfg, log, metrics.New()).SetupRoutes(router)
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("recording file created before any exchange (err %v)", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequestWithContext(ctx, http.MethodGet, "/svc/items", nil))
+
+	if info, err := os.Stat(path); err != nil || info.Size() == 0 {
+		t.Fatalf("exchange not recorded (err %v)", err)
+	}
+}